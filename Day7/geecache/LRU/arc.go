@@ -0,0 +1,241 @@
+package LRU
+
+import (
+	"container/list"
+	"time"
+)
+
+// ARC（自适应替换缓存）：t1保存只访问过一次的结点，t2保存访问过多次的结点，
+// b1、b2分别记录最近从t1、t2中淘汰的key（只记录key，不保存值，称为幽灵结点）
+// 如果新加入的key命中了b1，说明t1太小了，就增大t1的目标大小p；命中了b2则减小p
+// 这样就可以在"最近使用"和"经常使用"之间自动调整，一次性的扫描只会进入t1，不会冲掉t2
+// 原论文以结点个数作为大小，这里统一换成字节数
+type ARCCache struct {
+	maxBytes  int64
+	p         int64 //t1的目标字节数
+	t1, t2    *list.List
+	b1, b2    *list.List
+	t1Bytes   int64
+	t2Bytes   int64
+	b1Bytes   int64
+	b2Bytes   int64
+	cache     map[string]*list.Element //t1以及t2中的结点
+	ghosts    map[string]*list.Element //b1以及b2中的结点
+	OnEvicted func(key string, value Value, reason EvictReason)
+	now       func() time.Time
+}
+
+type arcEntry struct {
+	entry
+	inT2 bool
+}
+
+// 幽灵结点只需要记录key以及当时占用的字节数
+type ghostEntry struct {
+	key  string
+	size int64
+	inB2 bool
+}
+
+func NewARC(maxBytes int64, onEvicted func(string, Value, EvictReason)) *ARCCache {
+	return &ARCCache{
+		maxBytes:  maxBytes,
+		t1:        list.New(),
+		t2:        list.New(),
+		b1:        list.New(),
+		b2:        list.New(),
+		cache:     make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+		now:       time.Now,
+	}
+}
+
+func (c *ARCCache) Add(key string, value Value) {
+	c.AddWithExpiry(key, value, time.Time{})
+}
+
+func (c *ARCCache) AddWithExpiry(key string, value Value, expire time.Time) {
+	size := entrySize(key, value)
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*arcEntry)
+		old := entrySize(key, e.value)
+		e.value = value
+		e.expire = expire
+		c.promote(ele, old)
+		c.replace(false)
+		return
+	}
+	inB2 := false
+	if g, ok := c.ghosts[key]; ok {
+		ge := g.Value.(*ghostEntry)
+		if !ge.inB2 {
+			//命中b1，说明最近淘汰的只访问过一次的key又被访问了，增大t1
+			c.p = min64(c.p+adaptDelta(size, c.b2Bytes, c.b1Bytes), c.maxBytes)
+		} else {
+			//命中b2，说明经常访问的key被淘汰得太早了，减小t1
+			c.p = max64(c.p-adaptDelta(size, c.b1Bytes, c.b2Bytes), 0)
+			inB2 = true
+		}
+		c.removeGhost(g)
+		c.cache[key] = c.t2.PushFront(&arcEntry{entry: entry{key, value, expire}, inT2: true})
+		c.t2Bytes += size
+	} else {
+		c.cache[key] = c.t1.PushFront(&arcEntry{entry: entry{key, value, expire}})
+		c.t1Bytes += size
+	}
+	c.replace(inB2)
+	c.trimGhosts()
+}
+
+func (c *ARCCache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*arcEntry)
+	if e.expired(c.now()) {
+		c.removeElement(ele, EvictExpired, false)
+		return nil, false
+	}
+	c.promote(ele, entrySize(key, e.value))
+	return e.value, true
+}
+
+func (c *ARCCache) Remove(key string) bool {
+	if g, ok := c.ghosts[key]; ok {
+		c.removeGhost(g)
+	}
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved, false)
+		return true
+	}
+	return false
+}
+
+func (c *ARCCache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for _, l := range []*list.List{c.t1, c.t2} {
+		n += removeExpiredFrom(l, now,
+			func(ele *list.Element) *entry { return &ele.Value.(*arcEntry).entry },
+			func(ele *list.Element) { c.removeElement(ele, EvictExpired, false) })
+	}
+	return n
+}
+
+func (c *ARCCache) Len() int {
+	return len(c.cache)
+}
+
+func (c *ARCCache) Bytes() int64 {
+	return c.t1Bytes + c.t2Bytes
+}
+
+// 被访问之后移动到t2的头部，oldSize是结点之前占用的字节数
+func (c *ARCCache) promote(ele *list.Element, oldSize int64) {
+	e := ele.Value.(*arcEntry)
+	size := entrySize(e.key, e.value)
+	if e.inT2 {
+		c.t2Bytes += size - oldSize
+		c.t2.MoveToFront(ele)
+		return
+	}
+	c.t1.Remove(ele)
+	c.t1Bytes -= oldSize
+	e.inT2 = true
+	c.cache[e.key] = c.t2.PushFront(e)
+	c.t2Bytes += size
+}
+
+// 超出最大字节数的时候，根据p决定从t1还是t2中淘汰
+func (c *ARCCache) replace(inB2 bool) {
+	for c.maxBytes != 0 && c.t1Bytes+c.t2Bytes > c.maxBytes {
+		if c.t1.Len() > 0 && (c.t1Bytes > c.p || (inB2 && c.t1Bytes == c.p) || c.t2.Len() == 0) {
+			c.removeElement(c.t1.Back(), EvictCapacity, true)
+		} else {
+			c.removeElement(c.t2.Back(), EvictCapacity, true)
+		}
+	}
+}
+
+// 幽灵结点也不能无限增长：t1+b1不超过maxBytes，全部加起来不超过两倍的maxBytes
+func (c *ARCCache) trimGhosts() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.b1.Len() > 0 && c.t1Bytes+c.b1Bytes > c.maxBytes {
+		c.removeGhost(c.b1.Back())
+	}
+	for c.b2.Len() > 0 && c.t1Bytes+c.t2Bytes+c.b1Bytes+c.b2Bytes > 2*c.maxBytes {
+		c.removeGhost(c.b2.Back())
+	}
+}
+
+// ghost表示是否需要记录成幽灵结点，只有因为容量不足被淘汰的才需要
+func (c *ARCCache) removeElement(ele *list.Element, reason EvictReason, ghost bool) {
+	e := ele.Value.(*arcEntry)
+	size := entrySize(e.key, e.value)
+	if e.inT2 {
+		c.t2.Remove(ele)
+		c.t2Bytes -= size
+	} else {
+		c.t1.Remove(ele)
+		c.t1Bytes -= size
+	}
+	delete(c.cache, e.key)
+	if ghost {
+		g := &ghostEntry{key: e.key, size: size, inB2: e.inT2}
+		if e.inT2 {
+			c.ghosts[e.key] = c.b2.PushFront(g)
+			c.b2Bytes += size
+		} else {
+			c.ghosts[e.key] = c.b1.PushFront(g)
+			c.b1Bytes += size
+		}
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+func (c *ARCCache) removeGhost(ele *list.Element) {
+	g := ele.Value.(*ghostEntry)
+	if g.inB2 {
+		c.b2.Remove(ele)
+		c.b2Bytes -= g.size
+	} else {
+		c.b1.Remove(ele)
+		c.b1Bytes -= g.size
+	}
+	delete(c.ghosts, g.key)
+}
+
+// 论文中的调整幅度是max(|对面的幽灵链表|/|命中的幽灵链表|, 1)，这里再乘上结点的大小
+func adaptDelta(size, other, hit int64) int64 {
+	if hit > 0 && other > hit {
+		return size * other / hit
+	}
+	return size
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 先遍历只访问过一次的t1，再遍历t2，幽灵结点没有值，不会被遍历
+func (c *ARCCache) Walk(fn func(key string, value Value, expire time.Time)) {
+	for _, l := range []*list.List{c.t1, c.t2} {
+		walkList(l, func(ele *list.Element) *entry { return &ele.Value.(*arcEntry).entry }, fn)
+	}
+}
@@ -0,0 +1,171 @@
+package LRU
+
+import (
+	"container/list"
+	"sort"
+	"time"
+)
+
+// LFU（最不经常使用）：淘汰访问次数最少的结点，访问次数相同的时候淘汰最久没有访问的
+// 每一个访问次数对应一个链表，所有操作都是O(1)的
+type LFUCache struct {
+	maxBytes  int64 //最大字节数
+	nbytes    int64
+	cache     map[string]*list.Element
+	freqs     map[int]*list.List //访问次数 -> 这个访问次数的所有结点，链表头部是最近访问的
+	minFreq   int                //当前最小的访问次数，淘汰的时候从这里开始
+	OnEvicted func(key string, value Value, reason EvictReason)
+	now       func() time.Time
+}
+
+type lfuEntry struct {
+	entry
+	freq int
+}
+
+func NewLFU(maxBytes int64, onEvicted func(string, Value, EvictReason)) *LFUCache {
+	return &LFUCache{
+		maxBytes:  maxBytes,
+		cache:     make(map[string]*list.Element),
+		freqs:     make(map[int]*list.List),
+		OnEvicted: onEvicted,
+		now:       time.Now,
+	}
+}
+
+func (c *LFUCache) Add(key string, value Value) {
+	c.AddWithExpiry(key, value, time.Time{})
+}
+
+func (c *LFUCache) AddWithExpiry(key string, value Value, expire time.Time) {
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*lfuEntry)
+		c.nbytes += int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		c.touch(ele)
+	} else {
+		//先淘汰已有的结点腾出空间再放进去，否则新结点访问次数只有1，会马上把自己淘汰掉
+		size := entrySize(key, value)
+		c.evict(size)
+		e := &lfuEntry{entry: entry{key, value, expire}, freq: 1}
+		c.cache[key] = c.freqList(1).PushFront(e)
+		c.minFreq = 1
+		c.nbytes += size
+	}
+	c.evict(0)
+}
+
+// 淘汰访问次数最少的结点，直到还能再放下size个字节
+func (c *LFUCache) evict(size int64) {
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes+size && len(c.cache) > 0 {
+		l, ok := c.freqs[c.minFreq]
+		if !ok {
+			c.minFreq = c.lowestFreq()
+			continue
+		}
+		c.removeElement(l.Back(), EvictCapacity)
+	}
+}
+
+func (c *LFUCache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*lfuEntry)
+	if e.expired(c.now()) {
+		c.removeElement(ele, EvictExpired)
+		return nil, false
+	}
+	c.touch(ele)
+	return e.value, true
+}
+
+func (c *LFUCache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+func (c *LFUCache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for _, l := range c.freqs {
+		n += removeExpiredFrom(l, now,
+			func(ele *list.Element) *entry { return &ele.Value.(*lfuEntry).entry },
+			func(ele *list.Element) { c.removeElement(ele, EvictExpired) })
+	}
+	return n
+}
+
+func (c *LFUCache) Len() int {
+	return len(c.cache)
+}
+
+func (c *LFUCache) Bytes() int64 {
+	return c.nbytes
+}
+
+// 访问次数加一，移动到下一个访问次数的链表头部
+func (c *LFUCache) touch(ele *list.Element) {
+	e := ele.Value.(*lfuEntry)
+	l := c.freqs[e.freq]
+	l.Remove(ele)
+	if l.Len() == 0 {
+		delete(c.freqs, e.freq)
+		if c.minFreq == e.freq {
+			c.minFreq = e.freq + 1
+		}
+	}
+	e.freq++
+	c.cache[e.key] = c.freqList(e.freq).PushFront(e)
+}
+
+func (c *LFUCache) freqList(freq int) *list.List {
+	l, ok := c.freqs[freq]
+	if !ok {
+		l = list.New()
+		c.freqs[freq] = l
+	}
+	return l
+}
+
+// 最小的访问次数被删空之后，需要重新找到最小的访问次数
+func (c *LFUCache) lowestFreq() int {
+	min := 0
+	for f := range c.freqs {
+		if min == 0 || f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+func (c *LFUCache) removeElement(ele *list.Element, reason EvictReason) {
+	e := ele.Value.(*lfuEntry)
+	l := c.freqs[e.freq]
+	l.Remove(ele)
+	if l.Len() == 0 {
+		delete(c.freqs, e.freq)
+	}
+	delete(c.cache, e.key)
+	c.nbytes -= entrySize(e.key, e.value)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+// 访问次数从小到大，访问次数相同的从最久没有访问的开始
+func (c *LFUCache) Walk(fn func(key string, value Value, expire time.Time)) {
+	freqs := make([]int, 0, len(c.freqs))
+	for f := range c.freqs {
+		freqs = append(freqs, f)
+	}
+	sort.Ints(freqs)
+	for _, f := range freqs {
+		walkList(c.freqs[f], func(ele *list.Element) *entry { return &ele.Value.(*lfuEntry).entry }, fn)
+	}
+}
@@ -1,18 +1,49 @@
 package LRU
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
+
+// 淘汰原因，回调函数OnEvicted会带上这个原因，方便使用者区分是容量不足被淘汰还是过期被淘汰
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota //超出最大字节数被淘汰
+	EvictExpired                     //过期被淘汰
+	EvictRemoved                     //被使用者主动删除
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
 
 type Cache struct {
 	maxBytes  int64 //最大字节数
 	nbytes    int64
 	ll        *list.List //链表
 	cache     map[string]*list.Element
-	OnEvicted func(key string, value Value) //对应的一个回调函数
+	OnEvicted func(key string, value Value, reason EvictReason) //对应的一个回调函数
+	now       func() time.Time                                  //获取当前时间，测试时可以替换
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time //过期时间，零值表示永不过期
+}
+
+// 判断这个结点在now这个时刻是否已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
 }
 
 type Value interface {
@@ -20,12 +51,13 @@ type Value interface {
 }
 
 // 创建一个LRU的结构
-func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+func New(maxBytes int64, onEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
 		ll:        list.New(),
 		cache:     make(map[string]*list.Element),
 		OnEvicted: onEvicted,
+		now:       time.Now,
 	}
 }
 
@@ -33,28 +65,38 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele) //这里并没有实际意义上的删除，gc机制会在作用域结束之后自动帮你删除操作
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
-		}
-
+		c.removeElement(ele, EvictCapacity)
 	}
 
 }
 
+// 真正删除一个结点，并且触发对应的回调函数
+func (c *Cache) removeElement(ele *list.Element, reason EvictReason) {
+	c.ll.Remove(ele) //这里并没有实际意义上的删除，gc机制会在作用域结束之后自动帮你删除操作
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
 // 增加值的函数（将对应内容哈希查找到，然后删除，并放到头部）
 func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpiry(key, value, time.Time{})
+}
+
+// 增加一个带过期时间的值，expire为零值表示永不过期
+func (c *Cache) AddWithExpiry(key string, value Value, expire time.Time) {
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
 		//这行代码是将 ele.Value 强制转换为 *entry 类型
 		kv := ele.Value.(*entry) //进行断言，认为这个是entry类型，然后将其转化成entry类型
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
+		kv.expire = expire
 	} else {
-		ele := c.ll.PushFront(&entry{key, value})
+		ele := c.ll.PushFront(&entry{key, value, expire})
 		c.cache[key] = ele
 		c.nbytes += int64(len(key)) + int64(value.Len())
 
@@ -64,11 +106,15 @@ func (c *Cache) Add(key string, value Value) {
 	}
 }
 
-// 对应查找
+// 对应查找,如果发现已经过期了，那么就顺便删除（惰性删除）
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
+		if kv.expired(c.now()) {
+			c.removeElement(ele, EvictExpired)
+			return nil, false
+		}
+		c.ll.MoveToFront(ele)
 		return kv.value, true
 	}
 	//value 的类型是 Value（接口），因此默认会返回 nil。
@@ -76,7 +122,32 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 	return
 }
 
+// 主动删除一个key，返回这个key是否存在
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+// 删除所有已经过期的结点，返回删除的个数，给后台清理协程定期调用
+func (c *Cache) RemoveExpired() int {
+	return removeExpiredFrom(c.ll, c.now(),
+		func(ele *list.Element) *entry { return ele.Value.(*entry) },
+		func(ele *list.Element) { c.removeElement(ele, EvictExpired) })
+}
+
 // 实现了Cache的长度方法,对应值的接口实现在测试类里面有，可以供用户自定义长度
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// 返回当前所有结点占用的字节数
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+func (c *Cache) Walk(fn func(key string, value Value, expire time.Time)) {
+	walkList(c.ll, func(ele *list.Element) *entry { return ele.Value.(*entry) }, fn)
+}
@@ -3,6 +3,7 @@ package LRU
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 // 使用 go test 命令可以自动运行所有测试，方便进行批量测试。你可以轻松地检查所有测试的通过与失败，而不需要手动检查输出。
@@ -29,7 +30,7 @@ func TestGet(t *testing.T) {
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
 	//回调函数加入的时候只会加入对应的key值
-	callback := func(key string, value Value) {
+	callback := func(key string, value Value, reason EvictReason) {
 		//tempKeys := append([]string(nil), keys...) // 创建一个副本
 		//tempKeys = append(tempKeys, key)
 		keys = append(keys, key) //传了keys一个引用过去,如果需要传一个副本，这边可以自己创建对应的临时切片,如上面的示例
@@ -70,3 +71,51 @@ func TestRemoveoldset(t *testing.T) {
 	}
 
 }
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(100), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.now = func() time.Time { return now }
+	lru.AddWithExpiry("k1", String("v1"), now.Add(time.Second))
+	lru.AddWithExpiry("k2", String("v2"), now.Add(time.Minute))
+	lru.Add("k3", String("v3"))
+	if _, ok := lru.Get("k1"); !ok {
+		t.Fatal("k1 should not expire yet")
+	}
+	//时间往后拨两秒，k1应该被惰性删除
+	now = now.Add(2 * time.Second)
+	if _, ok := lru.Get("k1"); ok || lru.Len() != 2 {
+		t.Fatal("k1 should be expired")
+	}
+	if reasons["k1"] != EvictExpired {
+		t.Fatalf("expect reason %v, but %v got", EvictExpired, reasons["k1"])
+	}
+	//时间往后拨一小时，k2应该被清理，k3永不过期
+	now = now.Add(time.Hour)
+	if n := lru.RemoveExpired(); n != 1 || lru.Len() != 1 {
+		t.Fatalf("RemoveExpired removed %d, len %d", n, lru.Len())
+	}
+	if _, ok := lru.Get("k3"); !ok {
+		t.Fatal("k3 should never expire")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var reason EvictReason
+	lru := New(int64(100), func(key string, value Value, r EvictReason) {
+		reason = r
+	})
+	lru.Add("key1", String("1234"))
+	if !lru.Remove("key1") || reason != EvictRemoved {
+		t.Fatal("remove key1 failed")
+	}
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 || lru.nbytes != 0 {
+		t.Fatal("key1 should be removed")
+	}
+	if lru.Remove("key1") {
+		t.Fatal("remove a missing key should return false")
+	}
+}
@@ -0,0 +1,94 @@
+package LRU
+
+import (
+	"container/list"
+	"time"
+)
+
+// 淘汰策略的接口，上层的cache只通过这个接口使用，固然可以随意替换淘汰策略
+// Cache(LRU)、LFUCache、ARCCache、TinyLFUCache 都实现了这个接口，它们都不是并发安全的
+type Policy interface {
+	Add(key string, value Value)
+	AddWithExpiry(key string, value Value, expire time.Time)
+	Get(key string) (value Value, ok bool)
+	Remove(key string) bool
+	RemoveExpired() int
+	Len() int
+	Bytes() int64
+	//按照从最先被淘汰到最后被淘汰的顺序遍历所有结点，包括已经过期的，
+	//按照这个顺序重新Add一遍，就可以大致恢复出原来的状态
+	Walk(fn func(key string, value Value, expire time.Time))
+}
+
+// 淘汰策略的种类
+type PolicyType int
+
+const (
+	PolicyLRU     PolicyType = iota //最近最少使用，默认的策略
+	PolicyLFU                       //最不经常使用
+	PolicyARC                       //自适应替换，在LRU和LFU之间自动调整
+	PolicyTinyLFU                   //W-TinyLFU，新的key需要比被淘汰的key访问得更频繁才会被放进去，可以抵抗扫描
+)
+
+func (t PolicyType) String() string {
+	switch t {
+	case PolicyLRU:
+		return "lru"
+	case PolicyLFU:
+		return "lfu"
+	case PolicyARC:
+		return "arc"
+	case PolicyTinyLFU:
+		return "tinylfu"
+	}
+	return "unknown"
+}
+
+// 根据种类创建对应的淘汰策略，不认识的种类使用LRU
+func NewPolicy(t PolicyType, maxBytes int64, onEvicted func(string, Value, EvictReason)) Policy {
+	switch t {
+	case PolicyLFU:
+		return NewLFU(maxBytes, onEvicted)
+	case PolicyARC:
+		return NewARC(maxBytes, onEvicted)
+	case PolicyTinyLFU:
+		return NewTinyLFU(maxBytes, onEvicted)
+	default:
+		return New(maxBytes, onEvicted)
+	}
+}
+
+var (
+	_ Policy = (*Cache)(nil)
+	_ Policy = (*LFUCache)(nil)
+	_ Policy = (*ARCCache)(nil)
+	_ Policy = (*TinyLFUCache)(nil)
+)
+
+// 一个结点占用的字节数
+func entrySize(key string, value Value) int64 {
+	return int64(len(key)) + int64(value.Len())
+}
+
+// 从后往前遍历链表，对每一个已经过期的结点调用remove，返回过期结点的个数
+// 链表中的元素需要是*entry，或者通过getEntry转换成*entry
+func removeExpiredFrom(l *list.List, now time.Time, getEntry func(*list.Element) *entry, remove func(*list.Element)) int {
+	n := 0
+	for ele := l.Back(); ele != nil; {
+		prev := ele.Prev() //删除之后就拿不到前一个结点了，固然需要提前保存
+		if getEntry(ele).expired(now) {
+			remove(ele)
+			n++
+		}
+		ele = prev
+	}
+	return n
+}
+
+// 从链表尾部（最久没有访问的）往头部遍历
+func walkList(l *list.List, getEntry func(*list.Element) *entry, fn func(string, Value, time.Time)) {
+	for ele := l.Back(); ele != nil; ele = ele.Prev() {
+		e := getEntry(ele)
+		fn(e.key, e.value, e.expire)
+	}
+}
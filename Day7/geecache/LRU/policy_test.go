@@ -0,0 +1,229 @@
+package LRU
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var policyTypes = []PolicyType{PolicyLRU, PolicyLFU, PolicyARC, PolicyTinyLFU}
+
+// 所有的淘汰策略都需要满足的基本行为
+func TestPolicyBasics(t *testing.T) {
+	for _, typ := range policyTypes {
+		t.Run(typ.String(), func(t *testing.T) {
+			reasons := make(map[EvictReason]int)
+			p := NewPolicy(typ, 0, func(key string, value Value, reason EvictReason) {
+				reasons[reason]++
+			})
+			p.Add("key1", String("1234"))
+			if v, ok := p.Get("key1"); !ok || string(v.(String)) != "1234" {
+				t.Fatal("cache hit key1 =1234 failed")
+			}
+			if _, ok := p.Get("key2"); ok {
+				t.Fatal("cache miss key2 failed")
+			}
+			p.Add("key1", String("123"))
+			if p.Bytes() != int64(len("key1")+len("123")) || p.Len() != 1 {
+				t.Fatalf("unexpected bytes %d len %d", p.Bytes(), p.Len())
+			}
+			if !p.Remove("key1") || p.Len() != 0 || p.Bytes() != 0 || reasons[EvictRemoved] != 1 {
+				t.Fatal("remove key1 failed")
+			}
+			p.AddWithExpiry("k1", String("v1"), time.Now().Add(-time.Second))
+			p.AddWithExpiry("k2", String("v2"), time.Now().Add(-time.Second))
+			p.AddWithExpiry("k3", String("v3"), time.Now().Add(time.Hour))
+			if _, ok := p.Get("k1"); ok {
+				t.Fatal("k1 should be expired")
+			}
+			if n := p.RemoveExpired(); n != 1 || p.Len() != 1 || reasons[EvictExpired] != 2 {
+				t.Fatalf("RemoveExpired removed %d, len %d", n, p.Len())
+			}
+		})
+	}
+}
+
+func TestPolicyWalk(t *testing.T) {
+	for _, typ := range policyTypes {
+		t.Run(typ.String(), func(t *testing.T) {
+			p := NewPolicy(typ, 0, nil)
+			for _, key := range []string{"k1", "k2", "k3"} {
+				p.Add(key, String("v"))
+			}
+			p.Get("k1") //k1变成了最近访问的
+			var keys []string
+			p.Walk(func(key string, value Value, expire time.Time) {
+				keys = append(keys, key)
+			})
+			if len(keys) != 3 || keys[2] != "k1" {
+				t.Fatalf("k1 should be walked last, but %v got", keys)
+			}
+		})
+	}
+}
+
+// 不管使用什么淘汰策略，占用的字节数都不能超过maxBytes
+func TestPolicyMaxBytes(t *testing.T) {
+	for _, typ := range policyTypes {
+		t.Run(typ.String(), func(t *testing.T) {
+			evicted := 0
+			p := NewPolicy(typ, 1000, func(key string, value Value, reason EvictReason) {
+				if reason == EvictCapacity {
+					evicted++
+				}
+			})
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("key%d", r.Intn(500))
+				if _, ok := p.Get(key); !ok {
+					p.Add(key, String("0123456789"))
+				}
+				if p.Bytes() > 1000 {
+					t.Fatalf("bytes %d exceeds maxBytes", p.Bytes())
+				}
+			}
+			if evicted == 0 || p.Len() == 0 {
+				t.Fatalf("unexpected evicted %d len %d", evicted, p.Len())
+			}
+		})
+	}
+}
+
+func TestLFUEvictsLeastFrequent(t *testing.T) {
+	lfu := NewLFU(int64(len("k1v1k2v2")), nil)
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k2")
+	lfu.Add("k3", String("v3")) //已有的结点中k2访问次数最少，被淘汰的是k2
+	if _, ok := lfu.Get("k1"); !ok {
+		t.Fatal("k1 is the most frequent key, should not be evicted")
+	}
+	if _, ok := lfu.Get("k2"); ok {
+		t.Fatal("k2 is the least frequent key, should be evicted")
+	}
+}
+
+// 其他结点的访问次数都比1大时，新放进去的结点也不能马上把自己淘汰掉
+func TestLFUKeepsNewKey(t *testing.T) {
+	lfu := NewLFU(4, nil)
+	lfu.Add("a", String("1"))
+	lfu.Add("b", String("2"))
+	lfu.Get("a")
+	lfu.Get("b")
+	lfu.Add("c", String("3"))
+	if _, ok := lfu.Get("c"); !ok {
+		t.Fatal("c was just added, should not be evicted")
+	}
+	if lfu.Len() != 2 {
+		t.Fatalf("expect 2 entries, but %d got", lfu.Len())
+	}
+}
+
+// 只访问一次的扫描不应该把经常访问的key冲掉
+func TestScanResistance(t *testing.T) {
+	for _, typ := range []PolicyType{PolicyARC, PolicyTinyLFU} {
+		t.Run(typ.String(), func(t *testing.T) {
+			p := NewPolicy(typ, 2000, nil)
+			hot := make([]string, 20)
+			for i := range hot {
+				hot[i] = fmt.Sprintf("hot%02d", i)
+			}
+			for round := 0; round < 5; round++ {
+				for _, key := range hot {
+					if _, ok := p.Get(key); !ok {
+						p.Add(key, String("0123456789"))
+					}
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("scan%04d", i)
+				if _, ok := p.Get(key); !ok {
+					p.Add(key, String("0123456789"))
+				}
+			}
+			hits := 0
+			for _, key := range hot {
+				if _, ok := p.Get(key); ok {
+					hits++
+				}
+			}
+			if hits < len(hot)/2 {
+				t.Fatalf("only %d of %d hot keys survived the scan", hits, len(hot))
+			}
+		})
+	}
+}
+
+func TestCMSketch(t *testing.T) {
+	s := newCMSketch(64)
+	for i := 0; i < 5; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if s.estimate("hot") < 5 || s.estimate("cold") < 1 || s.estimate("hot") <= s.estimate("cold") {
+		t.Fatalf("unexpected estimate hot %d cold %d", s.estimate("hot"), s.estimate("cold"))
+	}
+	s.reset()
+	if s.estimate("hot") != 2 {
+		t.Fatalf("counters should be halved, but %d got", s.estimate("hot"))
+	}
+}
+
+// 生成访问序列：zipf表示少部分key被频繁访问，scan在zipf中间穿插一次性的顺序扫描
+func zipfTrace(n int, seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	z := rand.NewZipf(r, 1.1, 1, 10000)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%d", z.Uint64())
+	}
+	return trace
+}
+
+func scanTrace(n int, seed int64) []string {
+	trace := zipfTrace(n, seed)
+	scan := 0
+	for i := 0; i+2000 <= n; i += 10000 {
+		//每一万次访问中穿插两千个只访问一次的key
+		for j := 0; j < 2000; j++ {
+			trace[i+j] = fmt.Sprintf("scan%d", scan)
+			scan++
+		}
+	}
+	return trace
+}
+
+// 按照Get没有命中就Add的方式回放访问序列，返回命中率
+func hitRatio(p Policy, trace []string) float64 {
+	hits := 0
+	value := String("0123456789abcdef")
+	for _, key := range trace {
+		if _, ok := p.Get(key); ok {
+			hits++
+		} else {
+			p.Add(key, value)
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func BenchmarkHitRatio(b *testing.B) {
+	traces := map[string][]string{
+		"zipf": zipfTrace(100000, 1),
+		"scan": scanTrace(100000, 1),
+	}
+	for _, name := range []string{"zipf", "scan"} {
+		for _, typ := range policyTypes {
+			b.Run(name+"/"+typ.String(), func(b *testing.B) {
+				var ratio float64
+				for i := 0; i < b.N; i++ {
+					ratio = hitRatio(NewPolicy(typ, 500*24, nil), traces[name])
+				}
+				b.ReportMetric(ratio*100, "hit%")
+			})
+		}
+	}
+}
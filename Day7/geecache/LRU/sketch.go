@@ -0,0 +1,75 @@
+package LRU
+
+import "hash/fnv"
+
+const (
+	sketchDepth    = 4  //count-min sketch的行数
+	sketchMaxCount = 15 //每个计数器最大是15，和论文中4bit的计数器一致
+)
+
+// count-min sketch，用很少的内存近似统计每一个key的访问次数，只会多估不会少估
+// 为了让统计跟得上访问模式的变化，总次数达到resetAt之后所有计数器减半（论文中的reset）
+type cmSketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// width会被向上取整到2的幂
+func newCMSketch(width int) *cmSketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+	s := &cmSketch{mask: uint64(w - 1), resetAt: 10 * w}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// 计算出一个key在每一行中的位置，用两个哈希值组合出多个哈希值
+func (s *cmSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum, (sum>>32)|1
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *cmSketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < sketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// 返回所有行中最小的计数，作为这个key的访问次数
+func (s *cmSketch) estimate(key string) uint8 {
+	min := uint8(sketchMaxCount)
+	for i, idx := range s.indexes(key) {
+		if v := s.rows[i][idx]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
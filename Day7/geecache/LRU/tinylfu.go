@@ -0,0 +1,247 @@
+package LRU
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	tinyLFUWindowPercent    = 1  //窗口占总字节数的百分比
+	tinyLFUProtectedPercent = 80 //主缓存中protected段的百分比
+	tinyLFUAvgEntryBytes    = 64 //估计的平均结点大小，用来决定sketch的宽度
+	tinyLFUMinSketchWidth   = 1 << 10
+	tinyLFUMaxSketchWidth   = 1 << 20
+)
+
+// W-TinyLFU：新加入的结点先放在一个很小的LRU窗口中，从窗口中被挤出来之后作为候选者，
+// 只有候选者的访问频率（由count-min sketch估计）比主缓存中将要被淘汰的结点更高，才会被放入主缓存，
+// 这样批处理任务扫描出来的只访问一次的key就不会把主缓存中的热点数据冲掉
+// 主缓存是一个分段LRU：第一次进入时放在probation段，再次被访问时晋升到protected段
+// 论文：https://arxiv.org/abs/1512.00727
+type TinyLFUCache struct {
+	maxBytes       int64
+	windowMax      int64
+	protectedMax   int64
+	window         *list.List
+	probation      *list.List
+	protected      *list.List
+	windowBytes    int64
+	probationBytes int64
+	protectedBytes int64
+	cache          map[string]*list.Element
+	sketch         *cmSketch
+	OnEvicted      func(key string, value Value, reason EvictReason)
+	now            func() time.Time
+}
+
+type segment int
+
+const (
+	segWindow segment = iota
+	segProbation
+	segProtected
+)
+
+type tinyEntry struct {
+	entry
+	seg segment
+}
+
+func NewTinyLFU(maxBytes int64, onEvicted func(string, Value, EvictReason)) *TinyLFUCache {
+	windowMax := maxBytes * tinyLFUWindowPercent / 100
+	width := int(maxBytes / tinyLFUAvgEntryBytes)
+	if width < tinyLFUMinSketchWidth {
+		width = tinyLFUMinSketchWidth
+	}
+	if width > tinyLFUMaxSketchWidth {
+		width = tinyLFUMaxSketchWidth
+	}
+	return &TinyLFUCache{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		protectedMax: (maxBytes - windowMax) * tinyLFUProtectedPercent / 100,
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		cache:        make(map[string]*list.Element),
+		sketch:       newCMSketch(width),
+		OnEvicted:    onEvicted,
+		now:          time.Now,
+	}
+}
+
+func (c *TinyLFUCache) Add(key string, value Value) {
+	c.AddWithExpiry(key, value, time.Time{})
+}
+
+// 新的结点总是先放入窗口，访问频率只在Get中统计，
+// 因为正常的使用方式是Get没有命中之后再Add，避免同一次访问被统计两次
+func (c *TinyLFUCache) AddWithExpiry(key string, value Value, expire time.Time) {
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*tinyEntry)
+		*c.segBytes(e.seg) += int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		c.access(ele)
+	} else {
+		e := &tinyEntry{entry: entry{key, value, expire}, seg: segWindow}
+		c.cache[key] = c.window.PushFront(e)
+		c.windowBytes += entrySize(key, value)
+	}
+	c.evict()
+}
+
+func (c *TinyLFUCache) Get(key string) (value Value, ok bool) {
+	c.sketch.increment(key) //没有命中也需要统计，这样经常被访问却不在缓存中的key才有机会被放进来
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*tinyEntry)
+	if e.expired(c.now()) {
+		c.removeElement(ele, EvictExpired)
+		return nil, false
+	}
+	c.access(ele)
+	return e.value, true
+}
+
+func (c *TinyLFUCache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved)
+		return true
+	}
+	return false
+}
+
+func (c *TinyLFUCache) RemoveExpired() int {
+	now := c.now()
+	n := 0
+	for _, l := range []*list.List{c.window, c.probation, c.protected} {
+		n += removeExpiredFrom(l, now,
+			func(ele *list.Element) *entry { return &ele.Value.(*tinyEntry).entry },
+			func(ele *list.Element) { c.removeElement(ele, EvictExpired) })
+	}
+	return n
+}
+
+func (c *TinyLFUCache) Len() int {
+	return len(c.cache)
+}
+
+func (c *TinyLFUCache) Bytes() int64 {
+	return c.windowBytes + c.probationBytes + c.protectedBytes
+}
+
+func (c *TinyLFUCache) segList(seg segment) *list.List {
+	switch seg {
+	case segWindow:
+		return c.window
+	case segProbation:
+		return c.probation
+	default:
+		return c.protected
+	}
+}
+
+func (c *TinyLFUCache) segBytes(seg segment) *int64 {
+	switch seg {
+	case segWindow:
+		return &c.windowBytes
+	case segProbation:
+		return &c.probationBytes
+	default:
+		return &c.protectedBytes
+	}
+}
+
+// 把结点从原来的段移动到另外一个段的头部
+func (c *TinyLFUCache) moveTo(ele *list.Element, seg segment) {
+	e := ele.Value.(*tinyEntry)
+	size := entrySize(e.key, e.value)
+	c.segList(e.seg).Remove(ele)
+	*c.segBytes(e.seg) -= size
+	e.seg = seg
+	c.cache[e.key] = c.segList(seg).PushFront(e)
+	*c.segBytes(seg) += size
+}
+
+// 被访问之后：probation中的结点晋升到protected，protected满了之后把最老的降级回probation
+func (c *TinyLFUCache) access(ele *list.Element) {
+	e := ele.Value.(*tinyEntry)
+	if e.seg != segProbation {
+		c.segList(e.seg).MoveToFront(ele)
+		return
+	}
+	c.moveTo(ele, segProtected)
+	for c.maxBytes != 0 && c.protectedBytes > c.protectedMax && c.protected.Len() > 1 {
+		c.moveTo(c.protected.Back(), segProbation)
+	}
+}
+
+func (c *TinyLFUCache) mainMax() int64 {
+	return c.maxBytes - c.windowMax
+}
+
+func (c *TinyLFUCache) evict() {
+	if c.maxBytes == 0 {
+		return
+	}
+	//窗口满了之后，窗口中最老的结点作为候选者尝试进入主缓存
+	for c.windowBytes > c.windowMax && c.window.Len() > 0 {
+		c.admit(c.window.Back())
+	}
+	//更新已有的结点之后主缓存也可能会超出
+	for c.probationBytes+c.protectedBytes > c.mainMax() {
+		victim := c.mainVictim()
+		if victim == nil {
+			break
+		}
+		c.removeElement(victim, EvictCapacity)
+	}
+}
+
+// 主缓存中下一个要被淘汰的结点，先淘汰probation段的
+func (c *TinyLFUCache) mainVictim() *list.Element {
+	if ele := c.probation.Back(); ele != nil {
+		return ele
+	}
+	return c.protected.Back()
+}
+
+// TinyLFU的准入策略：候选者需要比被淘汰的结点访问得更频繁，否则候选者自己被淘汰
+func (c *TinyLFUCache) admit(cand *list.Element) {
+	e := cand.Value.(*tinyEntry)
+	size := entrySize(e.key, e.value)
+	if size > c.mainMax() {
+		c.removeElement(cand, EvictCapacity)
+		return
+	}
+	candFreq := c.sketch.estimate(e.key)
+	for c.probationBytes+c.protectedBytes+size > c.mainMax() {
+		victim := c.mainVictim()
+		if candFreq <= c.sketch.estimate(victim.Value.(*tinyEntry).key) {
+			c.removeElement(cand, EvictCapacity)
+			return
+		}
+		c.removeElement(victim, EvictCapacity)
+	}
+	c.moveTo(cand, segProbation)
+}
+
+func (c *TinyLFUCache) removeElement(ele *list.Element, reason EvictReason) {
+	e := ele.Value.(*tinyEntry)
+	c.segList(e.seg).Remove(ele)
+	*c.segBytes(e.seg) -= entrySize(e.key, e.value)
+	delete(c.cache, e.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value, reason)
+	}
+}
+
+// 按照probation、window、protected的顺序遍历，probation中的结点最先被淘汰
+func (c *TinyLFUCache) Walk(fn func(key string, value Value, expire time.Time)) {
+	for _, l := range []*list.List{c.probation, c.window, c.protected} {
+		walkList(l, func(ele *list.Element) *entry { return &ele.Value.(*tinyEntry).entry }, fn)
+	}
+}
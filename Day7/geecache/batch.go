@@ -0,0 +1,186 @@
+package geecache
+
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+// GetMulti中每一个key的结果
+type Result struct {
+	Key   string
+	Value ByteView
+	Err   error
+}
+
+// 一次拿取多个key，返回的结果和keys一一对应。
+// 缓存中没有的key会按照所在的远程结点分组，每一个结点只发送一次批量请求，
+// 剩下的key在本地并行加载，所有的请求都是同时进行的
+func (g *Group) GetMulti(keys []string) []Result {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// 和GetMulti一样，ctx会传递到远程请求以及Getter中
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) []Result {
+	results := make([]Result, len(keys))
+	//同一个key出现多次时只加载一次，记录下它在results中的所有位置
+	pending := make(map[string][]int)
+	var order []string
+	for i, key := range keys {
+		results[i].Key = key
+		if key == "" {
+			results[i].Err = fmt.Errorf("key is required")
+			continue
+		}
+		g.stats.Gets.Add(1)
+		if v, ok := g.lookupCache(key); ok {
+			g.stats.CacheHits.Add(1)
+			results[i].Value = v
+			continue
+		}
+		if g.negCache != nil {
+			if _, ok := g.negCache.get(key); ok {
+				g.stats.NegativeHits.Add(1)
+				results[i].Err = ErrNotFound
+				continue
+			}
+		}
+		if _, ok := pending[key]; !ok {
+			order = append(order, key)
+		}
+		pending[key] = append(pending[key], i)
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	set := func(key string, value ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, i := range pending[key] {
+			results[i].Value, results[i].Err = value, err
+		}
+	}
+	//按照远程结点分组，不能批量请求的结点以及本地的key都单独加载
+	var local []string
+	batches := make(map[PeerGetter]*peerBatch)
+	for _, key := range order {
+		if g.peers != nil && !isPeerRequest(ctx) {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				if bp, ok := peer.(PeerBatchGetter); ok {
+					group := batchGroupOf(peer)
+					if batches[group] == nil {
+						batches[group] = &peerBatch{getter: bp}
+					}
+					batches[group].keys = append(batches[group].keys, key)
+					continue
+				}
+			}
+		}
+		local = append(local, key)
+	}
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *peerBatch) {
+			defer wg.Done()
+			g.getMultiFromPeer(ctx, batch.getter, batch.keys, set)
+		}(batch)
+	}
+	for _, key := range local {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := g.load(ctx, key)
+			set(key, value, err)
+		}(key)
+	}
+	wg.Wait()
+	return results
+}
+
+// 发送给同一个远程结点的一批key
+type peerBatch struct {
+	getter PeerBatchGetter
+	keys   []string
+}
+
+// 同一个结点上的key放在一起批量请求。开启副本时每次PickPeer都会返回新的replicaGetter，
+// 固然按照最先请求的那个结点（通常就是owner）分组，这个结点失败之后按照第一个key的副本顺序重试
+func batchGroupOf(peer PeerGetter) PeerGetter {
+	if r, ok := peer.(*replicaGetter); ok && len(r.getters) > 0 {
+		return r.getters[0]
+	}
+	return peer
+}
+
+// 向一个远程结点批量请求keys，远程结点处理不了的key在本地加载
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerBatchGetter, keys []string, set func(string, ByteView, error)) {
+	out := &pb.BatchResponse{}
+	err := peer.GetMulti(ctx, &pb.BatchRequest{Group: g.name, Keys: keys}, out)
+	if err == nil && len(out.GetResponses()) != len(keys) {
+		err = fmt.Errorf("peer returned %d responses for %d keys", len(out.GetResponses()), len(keys))
+	}
+	if err != nil {
+		g.stats.PeerErrors.Add(int64(len(keys)))
+		log.Println("[GeeCache] Failed to get multi from peer", err)
+		g.getMultiLocally(ctx, keys, set)
+		return
+	}
+	var fallback []string
+	for i, res := range out.GetResponses() {
+		key := keys[i]
+		err := errorOf(res.GetCode(), res.GetMessage())
+		switch {
+		case err == nil:
+			g.stats.PeerLoads.Add(1)
+			value := ByteView{b: res.GetValue(), expire: expireOf(res.GetExpire())}
+			if g.hotCache.maxBytes() > 0 && rand.Float64() < g.hotCacheSampleRate {
+				g.hotCache.addWithExpiry(key, value, value.expire)
+			}
+			set(key, value, nil)
+		case errors.Is(err, ErrNotFound):
+			g.stats.PeerLoads.Add(1)
+			g.populateNegative(key)
+			set(key, ByteView{}, err)
+		case errors.Is(err, ErrLoadFailed):
+			//和load一样，远程结点的Getter失败了就不在本地再试一次
+			g.stats.PeerErrors.Add(1)
+			set(key, ByteView{}, err)
+		default:
+			g.stats.PeerErrors.Add(1)
+			fallback = append(fallback, key)
+		}
+	}
+	g.getMultiLocally(ctx, fallback, set)
+}
+
+// 在本地并行加载keys，同一个key的并发加载会被singleflight合并
+func (g *Group) getMultiLocally(ctx context.Context, keys []string, set func(string, ByteView, error)) {
+	if len(keys) > 0 && ctx.Err() != nil {
+		for _, key := range keys {
+			set(key, ByteView{}, ctx.Err())
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			viewi, err := g.loader.Do(key, func() (interface{}, error) {
+				return g.getLocally(ctx, key)
+			})
+			if err != nil {
+				set(key, ByteView{}, err)
+				return
+			}
+			set(key, viewi.(ByteView), nil)
+		}(key)
+	}
+	wg.Wait()
+}
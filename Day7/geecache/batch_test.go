@@ -0,0 +1,159 @@
+package geecache
+
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetMulti(t *testing.T) {
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	gee := NewGroup("multi", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			loads[key]++
+			mu.Unlock()
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+	gee.Get("Tom")
+	results := gee.GetMulti([]string{"Tom", "Jack", "unknown", "Jack", ""})
+	for i, key := range []string{"Tom", "Jack", "unknown", "Jack", ""} {
+		if results[i].Key != key {
+			t.Fatalf("results should keep the order of keys, but %s got at %d", results[i].Key, i)
+		}
+	}
+	if results[0].Value.String() != "630" || results[1].Value.String() != "589" || results[3].Value.String() != "589" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if !errors.Is(results[2].Err, ErrNotFound) || results[4].Err == nil {
+		t.Fatalf("unexpected errors %v %v", results[2].Err, results[4].Err)
+	}
+	//Tom已经在缓存中了，重复的Jack只加载一次
+	if loads["Tom"] != 1 || loads["Jack"] != 1 || loads["unknown"] != 1 {
+		t.Fatalf("unexpected loads %v", loads)
+	}
+}
+
+// 支持批量请求的远程结点，overloaded中的key会返回OVERLOADED
+type fakeBatchPeer struct {
+	fakePeer
+	mu         sync.Mutex
+	batches    [][]string
+	overloaded map[string]bool
+}
+
+func (p *fakeBatchPeer) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.mu.Lock()
+	p.batches = append(p.batches, in.GetKeys())
+	p.mu.Unlock()
+	for _, key := range in.GetKeys() {
+		switch {
+		case p.overloaded[key]:
+			out.Responses = append(out.Responses, errorResponse(ErrOverloaded))
+		case p.missing[key]:
+			out.Responses = append(out.Responses, errorResponse(ErrNotFound))
+		default:
+			out.Responses = append(out.Responses, &pb.Response{Value: []byte(p.values[key])})
+		}
+	}
+	return nil
+}
+
+// 以a开头的key属于peerA，以b开头的属于peerB，其他的属于自己
+type prefixPicker struct {
+	a, b *fakeBatchPeer
+}
+
+func (f *prefixPicker) PickPeer(key string) (PeerGetter, bool) {
+	switch {
+	case strings.HasPrefix(key, "a"):
+		return f.a, true
+	case strings.HasPrefix(key, "b"):
+		return f.b, true
+	}
+	return nil, false
+}
+
+func TestGetMultiPeers(t *testing.T) {
+	var mu sync.Mutex
+	var localKeys []string
+	gee := NewGroup("multi-peers", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			localKeys = append(localKeys, key)
+			mu.Unlock()
+			return []byte("local-" + key), nil
+		}))
+	a := &fakeBatchPeer{
+		fakePeer:   fakePeer{values: map[string]string{"a1": "A1", "a2": "A2"}, missing: map[string]bool{"a3": true}},
+		overloaded: map[string]bool{"a4": true},
+	}
+	b := &fakeBatchPeer{fakePeer: fakePeer{values: map[string]string{"b1": "B1"}}}
+	gee.RegisterPeers(&prefixPicker{a: a, b: b})
+
+	results := gee.GetMulti([]string{"a1", "b1", "c1", "a2", "a3", "a4"})
+	want := []string{"A1", "B1", "local-c1", "A2", "", "local-a4"}
+	for i, r := range results {
+		if r.Value.String() != want[i] {
+			t.Fatalf("%s: expect %q, but %q got, err %v", r.Key, want[i], r.Value.String(), r.Err)
+		}
+	}
+	if !errors.Is(results[4].Err, ErrNotFound) {
+		t.Fatalf("a3 should not be found, but %v got", results[4].Err)
+	}
+	//每一个结点只收到一次批量请求，过载的key以及自己负责的key在本地加载
+	if len(a.batches) != 1 || len(a.batches[0]) != 4 || len(b.batches) != 1 {
+		t.Fatalf("unexpected batches %v %v", a.batches, b.batches)
+	}
+	if len(localKeys) != 2 {
+		t.Fatalf("unexpected local loads %v", localKeys)
+	}
+}
+
+func TestGetMultiReplicas(t *testing.T) {
+	gee := NewGroup("multi-replicas", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	var batches atomic.Int64
+	newServer := func() *httptest.Server {
+		pool := NewHTTPPool("peer")
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.Path, batchPath) {
+				batches.Add(1)
+			}
+			pool.ServeHTTP(w, r)
+		}))
+	}
+	s1, s2 := newServer(), newServer()
+	defer s1.Close()
+	defer s2.Close()
+	pool := NewHTTPPool("http://self", WithReplication(2))
+	pool.Set(s1.URL, s2.URL)
+	gee.RegisterPeers(pool)
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	for _, r := range gee.GetMulti(keys) {
+		if r.Err != nil || r.Value.String() != r.Key {
+			t.Fatalf("%s: unexpected value %q, err %v", r.Key, r.Value.String(), r.Err)
+		}
+	}
+	//每一个key都有两个副本，按照owner分组之后每一个结点只收到一次批量请求
+	if n := batches.Load(); n != 2 {
+		t.Fatalf("expect 2 batch requests, but %d got", n)
+	}
+}
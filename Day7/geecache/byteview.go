@@ -1,8 +1,11 @@
 package geecache
 
+import "time"
+
 // 对这个Byte切片进行了一个封装，保证对应的数据不能被修改,即只读，不可修改
 type ByteView struct {
-	b []byte
+	b      []byte
+	expire time.Time //过期时间，零值表示永不过期，发给其他结点时一起带上，它们的hotCache才能按时过期
 }
 
 // 封装对应的长度方法
@@ -19,6 +22,22 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// 把pb中的unix纳秒转换成过期时间，0表示永不过期
+func expireOf(unixNano int64) time.Time {
+	if unixNano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, unixNano)
+}
+
+// 把过期时间转换成unix纳秒，永不过期时是0
+func unixNano(expire time.Time) int64 {
+	if expire.IsZero() {
+		return 0
+	}
+	return expire.UnixNano()
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
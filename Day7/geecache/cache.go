@@ -3,34 +3,200 @@ package geecache
 import (
 	"awesomeProject2/Day7/geecache/LRU"
 	"sync"
+	"time"
 )
 
 //这个类主要是可以增加缓存以及获取缓存
 //这里封装了对应的LRU这个数据结构，给他多封装一层锁,变成线程安全的缓存数据结构
 
+// 后台清理过期缓存的时间间隔
+const defaultSweepInterval = time.Minute
+
+// 缓存需要提供的操作，cache是只有一把锁的实现，shardedCache把key分散到多个cache上
+type cacheStore interface {
+	add(key string, value ByteView)
+	addWithExpiry(key string, value ByteView, expire time.Time)
+	//key不存在（或者已经过期）时才放入，返回是否放入了
+	addIfAbsent(key string, value ByteView, expire time.Time) bool
+	get(key string) (value ByteView, ok bool)
+	remove(key string)
+	removeExpired() int
+	stats() CacheStats
+	maxBytes() int64 //最大字节数，0表示没有限制
+	//按照从最先被淘汰到最后被淘汰的顺序返回所有没有过期的缓存
+	entries() []cacheItem
+}
+
+// 缓存中的一项，用于快照
+type cacheItem struct {
+	key    string
+	value  ByteView
+	expire time.Time
+}
+
+// 因为容量不足被淘汰的时候调用，用来把值写到磁盘上
+type spillFunc func(key string, value ByteView, expire time.Time)
+
+// 根据分片数创建对应的缓存，spill可以为nil
+func newCacheStore(cacheBytes int64, policy LRU.PolicyType, shards int, spill spillFunc) cacheStore {
+	if shards <= 1 {
+		return &cache{cacheBytes: cacheBytes, policy: policy, spill: spill}
+	}
+	return newShardedCache(cacheBytes, policy, shards, spill)
+}
+
+// 放到淘汰策略中的值，带上过期时间，这样被淘汰的时候可以连同过期时间一起写到磁盘上
+type cacheEntry struct {
+	view   ByteView
+	expire time.Time
+}
+
+func (e cacheEntry) Len() int {
+	return e.view.Len()
+}
+
 type cache struct {
 	mu         sync.Mutex
-	lru        *LRU.Cache
+	lru        LRU.Policy
 	cacheBytes int64
+	policy     LRU.PolicyType //使用的淘汰策略，默认是LRU
+	spill      spillFunc      //为nil表示被淘汰的值直接丢掉
+	spilled    []cacheItem    //持有锁时被淘汰、等待释放锁之后再写到磁盘上的值
+	nget, nhit int64          //查找次数以及命中次数
+	nevict     int64          //因为容量不足被淘汰的次数
 }
 
-func (c *cache) add(key string, value ByteView) {
+// 某一个缓存的统计信息
+type CacheStats struct {
+	Bytes     int64
+	Items     int64
+	Gets      int64
+	Hits      int64
+	Evictions int64
+}
+
+func (c *cache) stats() CacheStats {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	s := CacheStats{
+		Gets:      c.nget,
+		Hits:      c.nhit,
+		Evictions: c.nevict,
+	}
+	if c.lru != nil {
+		s.Bytes = c.lru.Bytes()
+		s.Items = int64(c.lru.Len())
+	}
+	return s
+}
+
+func (c *cache) maxBytes() int64 {
+	return c.cacheBytes
+}
+
+func (c *cache) add(key string, value ByteView) {
+	c.addWithExpiry(key, value, time.Time{})
+}
+
+// 增加一个带过期时间的缓存，expire为零值表示永不过期
+func (c *cache) addWithExpiry(key string, value ByteView, expire time.Time) {
+	c.mu.Lock()
+	c.addLocked(key, value, expire)
+	c.unlockAndSpill()
+}
+
+// 已经有这个key时保留原来的值，用于迁移缓存，迁移过来的值可能比本地的旧
+func (c *cache) addIfAbsent(key string, value ByteView, expire time.Time) bool {
+	c.mu.Lock()
+	if c.lru != nil {
+		if _, ok := c.lru.Get(key); ok {
+			c.mu.Unlock()
+			return false
+		}
+	}
+	c.addLocked(key, value, expire)
+	c.unlockAndSpill()
+	return true
+}
+
+// 调用的时候需要持有锁
+func (c *cache) addLocked(key string, value ByteView, expire time.Time) {
 	if c.lru == nil {
-		c.lru = LRU.New(c.cacheBytes, nil) //new一个对应的缓存，应该有很多个吧？
+		c.lru = LRU.NewPolicy(c.policy, c.cacheBytes, c.onEvicted) //new一个对应的缓存，应该有很多个吧？
+	}
+	value.expire = expire
+	c.lru.AddWithExpiry(key, cacheEntry{view: value, expire: expire}, expire)
+}
+
+// 释放锁，然后把持有锁时被淘汰的值写到磁盘上。
+// 写磁盘比较慢，放到锁外面进行，不会阻塞其他访问这个缓存的协程
+func (c *cache) unlockAndSpill() {
+	spilled := c.spilled
+	c.spilled = nil
+	c.mu.Unlock()
+	for _, item := range spilled {
+		c.spill(item.key, item.value, item.expire)
+	}
+}
+
+// 调用的时候已经持有锁了，需要写到磁盘上的值先记下来，释放锁之后再写
+func (c *cache) onEvicted(key string, value LRU.Value, reason LRU.EvictReason) {
+	if reason == LRU.EvictCapacity {
+		c.nevict++
+		if c.spill != nil {
+			e := value.(cacheEntry)
+			c.spilled = append(c.spilled, cacheItem{key: key, value: e.view, expire: e.expire})
+		}
 	}
-	c.lru.Add(key, value)
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.nget++
 	if c.lru == nil {
 		return
 	}
 	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+		c.nhit++
+		return v.(cacheEntry).view, ok
 	}
 	return
 }
+
+// 删除一个缓存
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	c.lru.Remove(key)
+}
+
+// 持有锁的时间只用来拷贝一份，不会等待写快照
+func (c *cache) entries() []cacheItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	items := make([]cacheItem, 0, c.lru.Len())
+	now := time.Now()
+	c.lru.Walk(func(key string, value LRU.Value, expire time.Time) {
+		if expire.IsZero() || now.Before(expire) {
+			items = append(items, cacheItem{key: key, value: value.(cacheEntry).view, expire: expire})
+		}
+	})
+	return items
+}
+
+// 删除所有已经过期的缓存
+func (c *cache) removeExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.RemoveExpired()
+}
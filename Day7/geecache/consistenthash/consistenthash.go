@@ -1,31 +1,45 @@
 package consistenthash
 
 import (
-	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
 
+// 虚拟结点的生成方式的版本，生成方式改变之后需要加1，
+// 不同版本的结点算出的哈希环不一样，不能混在一起使用。
+// 版本2：虚拟结点的名字是"结点#编号"，使用64位的哈希，哈希冲突时名字最小的结点胜出
+const Version = 2
+
 // 定义一个函数类型
-type Hash func(data []byte) uint32
+type Hash func(data []byte) uint64
 
 type Map struct {
 	hash     Hash
-	replicas int            //虚拟结点要创建多少个,即一个真实结点拥有多少个虚拟结点可以映射到这个真实结点
-	keys     []int          //表示一个哈希环
-	hashMap  map[int]string //一个虚拟结点映射到哪一个真实结点
-
+	replicas int      //虚拟结点要创建多少个,即一个真实结点拥有多少个虚拟结点可以映射到这个真实结点
+	keys     []uint64 //表示一个哈希环，不会有重复的值
+	//一个虚拟结点映射到哪些真实结点，按照名字排序，只有第一个真正生效，
+	//其他的是哈希冲突的结点，第一个被删除之后由下一个接替，这样结果和结点加入的顺序无关
+	hashMap map[uint64][]string
+	//有界负载：epsilon大于0时，Get会跳过负载超过(1+epsilon)倍平均负载的结点
+	epsilon   float64
+	loads     map[string]int64 //每一个真实结点当前的负载
+	totalLoad int64
+	weights   map[string]int //每一个真实结点的权重
+	total     int            //所有真实结点的权重之和
 }
 
-// 创建一致性哈希
+// 创建一致性哈希，fn为nil时使用64位的FNV-1a
 func New(replicas int, fn Hash) *Map {
 	m := &Map{
 		replicas: replicas,
 		hash:     fn,
-		hashMap:  make(map[int]string),
+		hashMap:  make(map[uint64][]string),
+		loads:    make(map[string]int64),
+		weights:  make(map[string]int),
 	}
 	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE
+		m.hash = hash64[[]byte]
 	}
 	return m
 }
@@ -33,30 +47,173 @@ func New(replicas int, fn Hash) *Map {
 // 增加一些keys到hash中
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			//创建replicas个虚拟结点hash,利用自定义哈希算法m.hash将其对应的位置返回并转化成int
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.AddWeighted(key, 1)
+	}
+}
+
+// 增加一个带权重的真实结点，它有replicas*weight个虚拟结点，
+// 固然分配到的key的比例和权重成正比，机器配置更好的结点可以设置更大的权重。
+// weight小于等于0时忽略，已经存在的结点也会被忽略，需要先Remove才能修改权重
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	if _, ok := m.weights[key]; ok {
+		return
+	}
+	m.weights[key] = weight
+	m.total += weight
+	added := false
+	for i := 0; i < m.replicas*weight; i++ {
+		//虚拟结点的名字中间加上分隔符，否则结点"1"的第12个和结点"11"的第2个虚拟结点名字一样
+		hash := m.hash([]byte(vnodeKey(key, i)))
+		claimants, ok := m.hashMap[hash]
+		if !ok {
 			//将其虚拟结点加入到对应的keys上
 			m.keys = append(m.keys, hash)
-			//将对应虚拟结点映射到真实结点
-			m.hashMap[hash] = key
+			added = true
 		}
+		//将对应虚拟结点映射到真实结点，哈希冲突时按照名字排序
+		claimants = append(claimants, key)
+		sort.Strings(claimants)
+		m.hashMap[hash] = claimants
+	}
+	if added {
 		//将对应元素排序
-		sort.Ints(m.keys)
+		sort.Slice(m.keys, func(i, j int) bool { return m.keys[i] < m.keys[j] })
 	}
 }
 
+// 第i个虚拟结点的名字
+func vnodeKey(node string, i int) string {
+	return node + "#" + strconv.Itoa(i)
+}
+
+// 第i个虚拟结点对应的真实结点
+func (m *Map) owner(i int) string {
+	return m.hashMap[m.keys[i]][0]
+}
+
 func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
 	}
+	idx := m.search(key)
+	if m.epsilon <= 0 {
+		//找到对应虚拟结点映射的真实结点
+		return m.owner(idx)
+	}
+	//顺时针查找第一个负载没有超过上限的结点，上限不小于平均负载，固然一定可以找到
+	for i := 0; i < len(m.keys); i++ {
+		node := m.owner((idx + i) % len(m.keys))
+		if m.loads[node] < m.maxLoad(node) {
+			return node
+		}
+	}
+	return m.owner(idx)
+}
+
+// 从key所在的位置开始顺时针查找，返回n个不同的真实结点，第一个就是Get返回的结点，
+// 真实结点不足n个时返回所有的真实结点
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	//最多绕哈希环一圈
+	return collectN(n, len(m.keys), m.search(key), m.owner)
+}
+
+// 返回key顺时针方向第一个虚拟结点的下标，调用之前需要保证哈希环不为空
+func (m *Map) search(key string) int {
 	//将key转化成切片，然后进行返回对应的虚拟结点
-	hash := int(m.hash([]byte(key)))
+	hash := m.hash([]byte(key))
 	//在已排序的切片 m.keys 中查找第一个大于或等于 hash 的元素的索引。
 	//如果找到了这样的元素，idx 将是该元素的索引；如果没有找到，idx 将是 len(m.keys)，即切片的长度。
 	idx := sort.Search(len(m.keys), func(i int) bool {
 		return m.keys[i] >= hash
 	})
-	//找到对应虚拟结点映射的真实结点
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	return idx % len(m.keys)
+}
+
+// 删除一些真实结点，以及它们对应的所有虚拟结点，其他结点的虚拟结点不受影响，
+// 所以只有原本映射到被删除结点上的key会重新映射
+func (m *Map) Remove(keys ...string) {
+	removed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		removed[key] = true
+		m.totalLoad -= m.loads[key]
+		delete(m.loads, key)
+		m.total -= m.weights[key]
+		delete(m.weights, key)
+	}
+	//原地过滤，保留下来的虚拟结点依旧是有序的
+	kept := m.keys[:0]
+	for _, hash := range m.keys {
+		claimants := m.hashMap[hash][:0]
+		for _, node := range m.hashMap[hash] {
+			if !removed[node] {
+				claimants = append(claimants, node)
+			}
+		}
+		//所有冲突的结点都被删除了，虚拟结点才会被删除
+		if len(claimants) == 0 {
+			delete(m.hashMap, hash)
+			continue
+		}
+		m.hashMap[hash] = claimants
+		kept = append(kept, hash)
+	}
+	m.keys = kept
+}
+
+// 哈希环的摘要，结点以及它们的权重、虚拟结点个数、哈希函数都一样时摘要才一样，
+// 不同结点之间可以通过比较摘要来确认算出的哈希环是否一致
+func (m *Map) Digest() uint64 {
+	h := uint64(Version)
+	buf := make([]byte, 0, 64)
+	for _, hash := range m.keys {
+		buf = strconv.AppendUint(buf[:0], hash, 16)
+		buf = append(buf, '=')
+		buf = append(buf, m.hashMap[hash][0]...)
+		h = mix64(h ^ hash64(buf))
+	}
+	return h
+}
+
+// 开启有界负载，论文：https://arxiv.org/abs/1608.01350
+// 每一个结点的负载上限是(1+epsilon)倍的平均负载（带权重时按照权重分摊），Get会跳过已经达到上限的结点，
+// 这样热点key集中的结点就不会被压垮，epsilon小于等于0表示关闭。
+// 负载需要使用者通过Inc以及Done来维护，例如正在处理的请求数
+func (m *Map) SetBoundedLoad(epsilon float64) {
+	m.epsilon = epsilon
+}
+
+// 结点的负载加1，不存在的结点会被忽略
+func (m *Map) Inc(node string) {
+	if _, ok := m.weights[node]; !ok {
+		return
+	}
+	m.loads[node]++
+	m.totalLoad++
+}
+
+// 结点的负载减1，和Inc成对调用
+func (m *Map) Done(node string) {
+	if m.loads[node] <= 0 {
+		return
+	}
+	m.loads[node]--
+	m.totalLoad--
+}
+
+// 返回结点当前的负载
+func (m *Map) Load(node string) int64 {
+	return m.loads[node]
+}
+
+// 结点允许的负载上限，算上即将分配的这一个，按照权重分摊：
+// ceil((1+epsilon)*(totalLoad+1)*weight/所有结点的权重之和)
+func (m *Map) maxLoad(node string) int64 {
+	share := float64(m.totalLoad+1) * float64(m.weights[node]) / float64(m.total)
+	return int64(math.Ceil(share * (1 + m.epsilon)))
 }
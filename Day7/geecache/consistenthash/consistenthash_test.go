@@ -1,15 +1,26 @@
 package consistenthash
 
 import (
+	"fmt"
+	"math"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
+// 测试用的哈希函数，数字本身就是哈希值，虚拟结点"6#2"的哈希值是26
+func intHash(key []byte) uint64 {
+	s := string(key)
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		s = s[i+1:] + s[:i]
+	}
+	n, _ := strconv.Atoi(s)
+	return uint64(n)
+}
+
 func TestHashing(t *testing.T) {
-	hash := New(3, func(key []byte) uint32 {
-		i, _ := strconv.Atoi(string(key))
-		return uint32(i)
-	})
+	hash := New(3, intHash)
 	hash.Add("6", "4", "2")
 	testCases := map[string]string{
 		"2":  "2",
@@ -30,3 +41,196 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, intHash)
+	hash.Add("6", "4", "2", "8")
+	hash.Remove("8")
+	//删除8之后，和没有添加8的时候一样
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s ,should have yielded %s ", k, v)
+		}
+	}
+	hash.Remove("6", "4", "2")
+	if hash.Get("2") != "" {
+		t.Errorf("empty map should yield nothing")
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, intHash)
+	if nodes := hash.GetN("2", 2); nodes != nil {
+		t.Fatalf("empty map should yield nothing, but %v got", nodes)
+	}
+	//虚拟结点：2 4 6 12 14 16 22 24 26
+	hash.Add("6", "4", "2")
+	testCases := []struct {
+		key   string
+		n     int
+		nodes []string
+	}{
+		{"2", 1, []string{"2"}},
+		{"11", 2, []string{"2", "4"}},
+		{"23", 2, []string{"4", "6"}},
+		{"27", 3, []string{"2", "4", "6"}}, //绕回环的开头
+		{"23", 5, []string{"4", "6", "2"}}, //只有3个真实结点
+	}
+	for _, tc := range testCases {
+		if nodes := hash.GetN(tc.key, tc.n); !reflect.DeepEqual(nodes, tc.nodes) {
+			t.Errorf("GetN(%s, %d) should have yielded %v, but %v got", tc.key, tc.n, tc.nodes, nodes)
+		}
+		if nodes := hash.GetN(tc.key, tc.n); nodes[0] != hash.Get(tc.key) {
+			t.Errorf("first node of GetN(%s) should be %s", tc.key, hash.Get(tc.key))
+		}
+	}
+}
+
+func TestRemoveRemapsOnlyAffectedKeys(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c", "d")
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key] = hash.Get(key)
+	}
+	hash.Remove("c")
+	for key, owner := range before {
+		now := hash.Get(key)
+		if owner != "c" && now != owner {
+			t.Fatalf("%s should stay on %s, but moved to %s", key, owner, now)
+		}
+		if now == "c" {
+			t.Fatalf("%s should not map to removed node", key)
+		}
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	hash := New(3, intHash)
+	hash.Add("6", "4", "2")
+	hash.SetBoundedLoad(0.25)
+	if hash.Get("11") != "2" {
+		t.Fatalf("Asking for 11 ,should have yielded 2")
+	}
+	//平均负载(2+1)/3=1，上限ceil(1.25)=2，2已经达到上限，顺时针跳到4
+	hash.Inc("2")
+	hash.Inc("2")
+	if hash.Get("11") != "4" {
+		t.Fatalf("2 is overloaded, 11 should have yielded 4, but %s got", hash.Get("11"))
+	}
+	hash.Done("2")
+	hash.Done("2")
+	if hash.Get("11") != "2" || hash.Load("2") != 0 {
+		t.Fatalf("11 should go back to 2, but %s got, load %d", hash.Get("11"), hash.Load("2"))
+	}
+	//删除结点之后它的负载不再计入总负载
+	hash.Inc("6")
+	hash.Remove("6")
+	if hash.totalLoad != 0 {
+		t.Fatalf("total load should be 0, but %d got", hash.totalLoad)
+	}
+}
+
+func TestBoundedLoadCapsSkew(t *testing.T) {
+	const nodes, keys = 10, 10000
+	const epsilon = 0.25
+	hash := New(50, nil)
+	for i := 0; i < nodes; i++ {
+		hash.Add(fmt.Sprintf("node%d", i))
+	}
+	hash.SetBoundedLoad(epsilon)
+	//每一个key都一直占用着分配到的结点
+	for i := 0; i < keys; i++ {
+		hash.Inc(hash.Get(fmt.Sprintf("key%d", i)))
+	}
+	limit := int64(math.Ceil((1 + epsilon) * keys / nodes))
+	for i := 0; i < nodes; i++ {
+		node := fmt.Sprintf("node%d", i)
+		if load := hash.Load(node); load > limit {
+			t.Errorf("%s has load %d, more than %d", node, load, limit)
+		}
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	const keys = 60000
+	hash := New(50, nil)
+	weights := map[string]int{"small": 1, "medium": 2, "large": 3}
+	total := 0
+	for node, weight := range weights {
+		hash.AddWeighted(node, weight)
+		total += weight
+	}
+	hash.AddWeighted("ignored", 0)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[hash.Get(fmt.Sprintf("key%d", i))]++
+	}
+	if counts["ignored"] != 0 {
+		t.Fatalf("node with weight 0 should own nothing, but %d keys got", counts["ignored"])
+	}
+	//每一个结点分到的key的比例和权重的比例相差不超过20%
+	for node, weight := range weights {
+		share := float64(counts[node]) / keys
+		want := float64(weight) / float64(total)
+		t.Logf("%s: weight %d, share %.3f, want %.3f", node, weight, share, want)
+		if math.Abs(share-want) > 0.2*want {
+			t.Errorf("%s owns %.3f of keys, want about %.3f", node, share, want)
+		}
+	}
+}
+
+func TestVirtualNodeKeys(t *testing.T) {
+	//原来的"编号+结点"会让结点"1"的第11个和结点"11"的第1个虚拟结点重复
+	hash := New(20, nil)
+	hash.Add("1", "11")
+	if len(hash.keys) != 40 {
+		t.Fatalf("expected 40 virtual nodes, but %d got", len(hash.keys))
+	}
+}
+
+func TestHashCollision(t *testing.T) {
+	//所有虚拟结点都冲突，名字最小的结点胜出，和加入的顺序无关
+	constant := func([]byte) uint64 { return 42 }
+	ab := New(3, constant)
+	ab.Add("a", "b")
+	ba := New(3, constant)
+	ba.Add("b", "a")
+	if ab.Get("key") != "a" || ba.Get("key") != "a" {
+		t.Fatalf("a should win the collision, but %s and %s got", ab.Get("key"), ba.Get("key"))
+	}
+	if ab.Digest() != ba.Digest() {
+		t.Fatal("rings with the same nodes should have the same digest")
+	}
+	//a被删除之后由b接替
+	ab.Remove("a")
+	if ab.Get("key") != "b" || !reflect.DeepEqual(ab.GetN("key", 2), []string{"b"}) {
+		t.Fatalf("b should take over after a is removed, but %s got", ab.Get("key"))
+	}
+	ab.Remove("b")
+	if ab.Get("key") != "" {
+		t.Fatal("empty map should yield nothing")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a := New(50, nil)
+	a.Add("a", "b", "c")
+	b := New(50, nil)
+	b.Add("c", "a", "b")
+	if a.Digest() != b.Digest() {
+		t.Fatal("rings with the same nodes should have the same digest")
+	}
+	b.Remove("c")
+	b.AddWeighted("c", 2)
+	if a.Digest() == b.Digest() {
+		t.Fatal("rings with different weights should have different digests")
+	}
+}
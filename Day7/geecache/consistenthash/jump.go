@@ -0,0 +1,84 @@
+package consistenthash
+
+import "sort"
+
+// Google的跳跃一致性哈希，论文：https://arxiv.org/abs/1406.2294
+// 不需要任何额外的内存，计算也很快，但是结点只能按照编号0到n-1排列：
+// 只有在末尾增加或者删除结点时才只移动1/n的key，删除中间的结点会让后面的编号都发生变化。
+// 为了让每一个结点算出同样的编号，结点按照名字排序，权重为w的结点占用w个连续的编号
+type Jump struct {
+	weights map[string]int
+	buckets []string //编号对应的结点
+}
+
+func NewJump() *Jump {
+	return &Jump{weights: make(map[string]int)}
+}
+
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		j.AddWeighted(node, 1)
+	}
+}
+
+// 已经存在的结点会被忽略
+func (j *Jump) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	if _, ok := j.weights[node]; ok {
+		return
+	}
+	j.weights[node] = weight
+	j.build()
+}
+
+func (j *Jump) Remove(nodes ...string) {
+	for _, node := range nodes {
+		delete(j.weights, node)
+	}
+	j.build()
+}
+
+func (j *Jump) build() {
+	names := make([]string, 0, len(j.weights))
+	for name := range j.weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	j.buckets = j.buckets[:0]
+	for _, name := range names {
+		for i := 0; i < j.weights[name]; i++ {
+			j.buckets = append(j.buckets, name)
+		}
+	}
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(hash64(key), len(j.buckets))]
+}
+
+// 从Get的编号开始向后查找不同的结点
+func (j *Jump) GetN(key string, n int) []string {
+	if len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+	start := jumpHash(hash64(key), len(j.buckets))
+	return collectN(n, len(j.buckets), start, func(i int) string { return j.buckets[i] })
+}
+
+// 论文中的算法，返回[0, numBuckets)之间的编号
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+var _ Placement = (*Jump)(nil)
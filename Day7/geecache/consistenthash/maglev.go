@@ -0,0 +1,130 @@
+package consistenthash
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 默认的查找表大小，需要是质数，并且远大于结点数
+const defaultMaglevTableSize = 65537
+
+// Google的Maglev哈希，论文：https://research.google/pubs/pub44824/
+// 每一个结点根据自己的名字算出一个排列，轮流在查找表中占位，Get只需要查一次表。
+// 查找表大小为M时，每一个结点占用的格子数最多相差1，分布非常均匀，
+// 代价是结点变化时需要重建整个查找表，并且会有少量不相关的key被移动
+type Maglev struct {
+	size    int
+	weights map[string]int
+	names   []string //按照名字排序的结点，查找表中保存的是这里的下标
+	table   []int32
+}
+
+// size小于等于0时使用默认大小65537，size需要是质数，否则排列不能覆盖所有的格子，
+// 建查找表时会一直找不到空的格子
+func NewMaglev(size int) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevTableSize
+	}
+	if !isPrime(size) {
+		panic(fmt.Sprintf("maglev table size %d is not a prime", size))
+	}
+	return &Maglev{size: size, weights: make(map[string]int)}
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Maglev) Add(nodes ...string) {
+	for _, node := range nodes {
+		m.AddWeighted(node, 1)
+	}
+}
+
+// 权重为w的结点每一轮占w个格子，已经存在的结点会被忽略
+func (m *Maglev) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	if _, ok := m.weights[node]; ok {
+		return
+	}
+	m.weights[node] = weight
+	m.build()
+}
+
+func (m *Maglev) Remove(nodes ...string) {
+	for _, node := range nodes {
+		delete(m.weights, node)
+	}
+	m.build()
+}
+
+// 重建查找表，结点按照名字排序后轮流占位，保证每一个结点算出同样的查找表
+func (m *Maglev) build() {
+	m.names = m.names[:0]
+	for name := range m.weights {
+		m.names = append(m.names, name)
+	}
+	sort.Strings(m.names)
+	if len(m.names) == 0 {
+		m.table = nil
+		return
+	}
+	size := uint64(m.size)
+	offsets := make([]uint64, len(m.names))
+	skips := make([]uint64, len(m.names))
+	next := make([]uint64, len(m.names))
+	for i, name := range m.names {
+		h := hash64(name)
+		offsets[i] = h % size
+		skips[i] = mix64(h^0x9e3779b97f4a7c15)%(size-1) + 1
+	}
+	m.table = make([]int32, m.size)
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	for filled := 0; ; {
+		for i, name := range m.names {
+			for w := 0; w < m.weights[name]; w++ {
+				//按照自己的排列找到下一个空的格子
+				c := (offsets[i] + next[i]*skips[i]) % size
+				for m.table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % size
+				}
+				m.table[c] = int32(i)
+				next[i]++
+				if filled++; filled == m.size {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.names[m.table[hash64(key)%uint64(m.size)]]
+}
+
+// 从Get的格子开始向后查找不同的结点
+func (m *Maglev) GetN(key string, n int) []string {
+	if len(m.table) == 0 || n <= 0 {
+		return nil
+	}
+	start := int(hash64(key) % uint64(m.size))
+	return collectN(n, m.size, start, func(i int) string { return m.names[m.table[i]] })
+}
+
+var _ Placement = (*Maglev)(nil)
@@ -0,0 +1,59 @@
+package consistenthash
+
+// 决定key属于哪一个结点的算法，哈希环Map就是其中一种，
+// 所有结点需要使用同样的算法以及同样的结点列表，才能算出同样的结果。
+// 和Map一样不是并发安全的，需要使用者自己加锁
+type Placement interface {
+	Add(nodes ...string)
+	AddWeighted(node string, weight int) //weight小于等于0时忽略
+	Remove(nodes ...string)
+	Get(key string) string           //没有结点时返回空字符串
+	GetN(key string, n int) []string //n个不同的结点，第一个就是Get返回的结点
+}
+
+// Placement 可以选择实现这个接口，支持有界负载，
+// 使用者在请求开始时调用Inc，结束后调用Done，Get会避开负载过高的结点
+type LoadTracker interface {
+	Inc(node string)
+	Done(node string)
+	Load(node string) int64
+}
+
+var _ Placement = (*Map)(nil)
+var _ LoadTracker = (*Map)(nil)
+
+// 64位的FNV-1a，再经过splitmix64的混合，相近的字符串也能得到差别很大的哈希值，
+// 这里直接计算，避免每次都分配一个hash.Hash64
+func hash64[T string | []byte](data T) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(data); i++ {
+		h ^= uint64(data[i])
+		h *= 1099511628211
+	}
+	return mix64(h)
+}
+
+// splitmix64的最后一步
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// 从下标start开始向后绕一圈，收集n个不同的结点，Jump以及Maglev的GetN使用
+func collectN(n, size, start int, nodeAt func(i int) string) []string {
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < size && len(nodes) < n; i++ {
+		node := nodeAt((start + i) % size)
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
@@ -0,0 +1,177 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", func() Placement { return New(50, nil) }},
+	{"rendezvous", func() Placement { return NewRendezvous() }},
+	{"jump", func() Placement { return NewJump() }},
+	{"maglev", func() Placement { return NewMaglev(0) }},
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node%02d", i)
+	}
+	return nodes
+}
+
+func owners(p Placement, keys int) []string {
+	owners := make([]string, keys)
+	for i := range owners {
+		owners[i] = p.Get(fmt.Sprintf("key%d", i))
+	}
+	return owners
+}
+
+func TestPlacements(t *testing.T) {
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := pl.new()
+			if p.Get("key") != "" || p.GetN("key", 2) != nil {
+				t.Fatal("empty placement should yield nothing")
+			}
+			p.Add("a", "b", "c")
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key%d", i)
+				nodes := p.GetN(key, 2)
+				if len(nodes) != 2 || nodes[0] != p.Get(key) || nodes[0] == nodes[1] {
+					t.Fatalf("GetN(%s, 2) returned %v, Get returned %s", key, nodes, p.Get(key))
+				}
+				if nodes := p.GetN(key, 5); len(nodes) != 3 {
+					t.Fatalf("GetN(%s, 5) should return all 3 nodes, but %v got", key, nodes)
+				}
+			}
+			p.Remove("a", "b", "c")
+			if p.Get("key") != "" {
+				t.Fatal("empty placement should yield nothing")
+			}
+		})
+	}
+}
+
+// 增加或者删除一个结点时，被重新映射的key的比例，理想情况是1/n
+func TestPlacementRemap(t *testing.T) {
+	const keys = 20000
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := pl.new()
+			p.Add(nodeNames(10)...)
+			before := owners(p, keys)
+
+			//在末尾增加一个结点，被移动的key只能移动到新的结点上
+			p.Add("node10")
+			after := owners(p, keys)
+			moved, toOthers := 0, 0
+			for i := range before {
+				if before[i] != after[i] {
+					moved++
+					if after[i] != "node10" {
+						toOthers++
+					}
+				}
+			}
+			added := float64(moved) / keys
+			t.Logf("add: %.3f of keys remapped, ideal %.3f, %d moved between old nodes", added, 1.0/11, toOthers)
+			if added > 1.5/11 {
+				t.Errorf("adding a node remapped %.3f of keys, want about %.3f", added, 1.0/11)
+			}
+			//Maglev重建查找表时会有少量key在旧结点之间移动
+			if pl.name != "maglev" && toOthers != 0 {
+				t.Errorf("%d keys moved between old nodes", toOthers)
+			}
+
+			//删除刚刚增加的结点，回到原来的样子
+			p.Remove("node10")
+			for i, owner := range owners(p, keys) {
+				if owner != before[i] {
+					t.Fatalf("key%d should go back to %s, but %s got", i, before[i], owner)
+				}
+			}
+
+			//删除中间的结点，跳跃一致性哈希后面的编号都会变化，这里只记录结果
+			p.Remove("node05")
+			moved = 0
+			for i, owner := range owners(p, keys) {
+				if owner != before[i] {
+					moved++
+				}
+			}
+			removed := float64(moved) / keys
+			t.Logf("remove: %.3f of keys remapped, ideal %.3f", removed, 1.0/10)
+			if pl.name != "jump" && removed > 1.5/10 {
+				t.Errorf("removing a node remapped %.3f of keys, want about %.3f", removed, 1.0/10)
+			}
+		})
+	}
+}
+
+func TestPlacementWeights(t *testing.T) {
+	const keys = 60000
+	weights := map[string]int{"small": 1, "medium": 2, "large": 3}
+	for _, pl := range placements {
+		t.Run(pl.name, func(t *testing.T) {
+			p := pl.new()
+			for _, node := range []string{"small", "medium", "large"} {
+				p.AddWeighted(node, weights[node])
+			}
+			counts := make(map[string]int)
+			for _, owner := range owners(p, keys) {
+				counts[owner]++
+			}
+			for node, weight := range weights {
+				share := float64(counts[node]) / keys
+				want := float64(weight) / 6
+				if math.Abs(share-want) > 0.2*want {
+					t.Errorf("%s owns %.3f of keys, want about %.3f", node, share, want)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	for _, pl := range placements {
+		for _, n := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/%d", pl.name, n), func(b *testing.B) {
+				p := pl.new()
+				p.Add(nodeNames(n)...)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i%len(keys)])
+				}
+			})
+		}
+	}
+}
+
+func TestMaglevTableSize(t *testing.T) {
+	for _, size := range []int{1, 2, 4, 65536} {
+		func() {
+			defer func() {
+				if r := recover(); (r != nil) == (size == 2) {
+					t.Errorf("NewMaglev(%d) panic %v", size, r)
+				}
+			}()
+			NewMaglev(size)
+		}()
+	}
+	//很小的质数也可以正常工作
+	m := NewMaglev(2)
+	m.Add("a", "b")
+	if m.Get("key") == "" {
+		t.Fatal("maglev with table size 2 should pick a node")
+	}
+}
@@ -0,0 +1,108 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// 最高随机权重哈希（HRW），每一个结点和key一起算出一个分数，分数最高的结点就是owner。
+// 不需要虚拟结点，分布也更均匀，删除一个结点时只有它的key会重新映射，增加一个结点时只有被它抢走的key会重新映射，
+// 代价是Get需要遍历所有结点，适合结点数不多的时候
+type Rendezvous struct {
+	nodes []rendezvousNode
+}
+
+type rendezvousNode struct {
+	name   string
+	hash   uint64
+	weight float64
+}
+
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{}
+}
+
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		r.AddWeighted(node, 1)
+	}
+}
+
+// 带权重的HRW：分数是weight/-ln(u)，u是key和结点算出的(0,1)之间的随机数，
+// 这样每一个结点得到key的概率和权重成正比。已经存在的结点会被忽略
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	for _, n := range r.nodes {
+		if n.name == node {
+			return
+		}
+	}
+	r.nodes = append(r.nodes, rendezvousNode{name: node, hash: hash64(node), weight: float64(weight)})
+}
+
+func (r *Rendezvous) Remove(nodes ...string) {
+	removed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		removed[node] = true
+	}
+	kept := r.nodes[:0]
+	for _, n := range r.nodes {
+		if !removed[n.name] {
+			kept = append(kept, n)
+		}
+	}
+	r.nodes = kept
+}
+
+func (r *Rendezvous) score(keyHash uint64, n rendezvousNode) float64 {
+	h := mix64(keyHash ^ n.hash)
+	//取高53位转换成(0,1)之间的浮点数，不会等于0或者1
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return n.weight / -math.Log(u)
+}
+
+func (r *Rendezvous) Get(key string) string {
+	keyHash := hash64(key)
+	best, bestScore := "", -1.0
+	for _, n := range r.nodes {
+		//分数相同时按照名字选，保证每一个结点算出同样的结果
+		if s := r.score(keyHash, n); s > bestScore || (s == bestScore && n.name < best) {
+			best, bestScore = n.name, s
+		}
+	}
+	return best
+}
+
+// 分数最高的n个结点
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	keyHash := hash64(key)
+	type scored struct {
+		name  string
+		score float64
+	}
+	all := make([]scored, len(r.nodes))
+	for i, node := range r.nodes {
+		all[i] = scored{node.name, r.score(keyHash, node)}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].name < all[j].name
+	})
+	if n > len(all) {
+		n = len(all)
+	}
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = all[i].name
+	}
+	return nodes
+}
+
+var _ Placement = (*Rendezvous)(nil)
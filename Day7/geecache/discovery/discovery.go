@@ -0,0 +1,626 @@
+package discovery
+
+// 一个简化版的SWIM协议，结点之间通过UDP互相发现以及检测故障
+// 1.每隔ProbeInterval随机挑选一个结点发送ping，ProbeTimeout之内没有收到ack，
+//   就请求另外IndirectChecks个结点帮忙ping（ping-req），防止只是自己和它之间的网络有问题
+// 2.一轮下来都没有收到ack，就把它标记为suspect（怀疑），并且广播出去，
+//   suspect状态的结点如果在SuspicionTimeout之内没有反驳（用更大的incarnation广播自己alive），就被认定为dead
+// 3.所有状态的变化都捎带在ping/ack等消息里面传播（gossip），不需要额外的广播消息
+// 论文：https://www.cs.cornell.edu/projects/Quicksilver/public_pdfs/SWIM.pdf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProbeInterval  = time.Second
+	defaultIndirectChecks = 3
+	retransmitMult        = 3    //每一条状态变化会被捎带 retransmitMult*log(n+1) 次
+	maxPiggyback          = 16   //每一个消息最多捎带多少条状态变化
+	maxPacketSize         = 1400 //尽量保证一个消息不超过一个MTU
+)
+
+// 结点成员变化的时候通知给谁，HTTPPool实现了这个接口
+type Membership interface {
+	AddPeers(peers ...string)
+	RemovePeers(peers ...string)
+}
+
+type Config struct {
+	BindAddr         string        //监听的UDP地址，例如127.0.0.1:7001，端口为0时随机分配
+	Meta             string        //这个结点对外提供缓存服务的地址，例如http://localhost:8001，会被传给Membership
+	Seeds            []string      //启动时用来加入集群的种子结点的UDP地址
+	ProbeInterval    time.Duration //多久探测一次其他结点
+	ProbeTimeout     time.Duration //直接ping的超时时间，超时之后开始间接探测，默认是ProbeInterval的一半
+	SuspicionTimeout time.Duration //suspect状态持续多久之后认定为dead，默认是5个ProbeInterval
+	IndirectChecks   int           //间接探测时请求多少个结点帮忙
+	Membership       Membership    //可以为nil
+}
+
+// 结点的状态
+type State int
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// 集群中的一个结点
+type Member struct {
+	Addr        string //UDP地址，作为结点的唯一标识
+	Meta        string
+	State       State
+	Incarnation uint64 //只有结点自己可以增加，用来反驳别人对自己的怀疑
+}
+
+type msgType int
+
+const (
+	msgPing msgType = iota
+	msgPingReq
+	msgAck
+	msgJoin     //新结点请求加入
+	msgJoinSync //种子结点把完整的成员列表发送给新结点
+)
+
+type message struct {
+	Type    msgType  `json:"t"`
+	Seq     uint64   `json:"s,omitempty"`
+	From    string   `json:"f"`
+	Target  string   `json:"g,omitempty"` //ping-req需要探测的结点
+	Updates []Member `json:"u,omitempty"` //捎带的状态变化
+}
+
+// 等待广播出去的一条状态变化
+type broadcast struct {
+	member    Member
+	transmits int //已经被捎带了多少次
+}
+
+type Node struct {
+	cfg  Config
+	conn *net.UDPConn
+	self string //自己的UDP地址
+
+	mu          sync.Mutex
+	incarnation uint64
+	members     map[string]*Member
+	broadcasts  []*broadcast
+	probeOrder  []string //一轮探测的顺序，每一轮都重新打乱
+	seq         uint64
+	acks        map[uint64]func() //收到对应seq的ack之后调用
+	suspicions  map[string]*time.Timer
+	seeds       []string //还没有加入集群时，每一次探测都会重新向这些种子结点发送加入请求
+	joined      bool     //收到了种子结点的成员列表或者发现了其他结点
+
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	closeMu sync.Once
+}
+
+// 创建结点并且开始监听以及探测，Seeds不为空的时候会尝试加入集群
+func Start(cfg Config) (*Node, error) {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = cfg.ProbeInterval / 2
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = 5 * cfg.ProbeInterval
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = defaultIndirectChecks
+	}
+	addr, err := net.ResolveUDPAddr("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:  cfg,
+		conn: conn,
+		self: conn.LocalAddr().String(),
+		//用启动时间作为初始的incarnation，这样重启之后的结点总是比之前的新
+		incarnation: uint64(time.Now().UnixNano()),
+		members:     make(map[string]*Member),
+		acks:        make(map[uint64]func()),
+		suspicions:  make(map[string]*time.Timer),
+		stop:        make(chan struct{}),
+	}
+	n.members[n.self] = &Member{Addr: n.self, Meta: cfg.Meta, State: StateAlive, Incarnation: n.incarnation}
+	if cfg.Membership != nil && cfg.Meta != "" {
+		cfg.Membership.AddPeers(cfg.Meta)
+	}
+	n.wg.Add(2)
+	go n.readLoop()
+	go n.probeLoop()
+	if len(cfg.Seeds) > 0 {
+		n.Join(cfg.Seeds...)
+	}
+	return n, nil
+}
+
+// 返回自己的UDP地址
+func (n *Node) Addr() string {
+	return n.self
+}
+
+// 向种子结点发送加入请求，种子结点会把完整的成员列表发送回来。
+// 种子结点可能还没有启动，加入集群之前每一次探测都会重新发送
+func (n *Node) Join(seeds ...string) {
+	n.mu.Lock()
+	self := *n.members[n.self]
+	n.seeds = append(n.seeds, seeds...)
+	n.mu.Unlock()
+	n.sendJoin(self, seeds)
+}
+
+// 还没有加入集群时，重新向所有的种子结点发送加入请求
+func (n *Node) rejoin() {
+	n.mu.Lock()
+	if n.joined || len(n.seeds) == 0 {
+		n.mu.Unlock()
+		return
+	}
+	self := *n.members[n.self]
+	seeds := n.seeds
+	n.mu.Unlock()
+	n.sendJoin(self, seeds)
+}
+
+func (n *Node) sendJoin(self Member, seeds []string) {
+	for _, seed := range seeds {
+		if seed == n.self {
+			continue
+		}
+		n.send(seed, &message{Type: msgJoin, From: n.self, Updates: []Member{self}})
+	}
+}
+
+// 返回当前所有没有被认定为dead的结点，包括自己，按照地址排序
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		if m.State != StateDead {
+			members = append(members, *m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	return members
+}
+
+// 主动离开集群：广播自己dead，等待消息传播出去之后再关闭
+func (n *Node) Leave(wait time.Duration) error {
+	n.mu.Lock()
+	self := n.members[n.self]
+	self.State = StateDead
+	dead := *self
+	targets := n.aliveLocked()
+	n.mu.Unlock()
+	//直接告诉所有结点，不用等待gossip慢慢传播
+	for _, target := range targets {
+		n.send(target, &message{Type: msgPing, From: n.self, Updates: []Member{dead}})
+	}
+	time.Sleep(wait)
+	return n.Close()
+}
+
+// 直接关闭，不通知其他结点，其他结点会通过故障检测发现
+func (n *Node) Close() error {
+	var err error
+	n.closeMu.Do(func() {
+		close(n.stop)
+		err = n.conn.Close()
+		n.wg.Wait()
+		n.mu.Lock()
+		for _, t := range n.suspicions {
+			t.Stop()
+		}
+		n.mu.Unlock()
+	})
+	return err
+}
+
+func (n *Node) readLoop() {
+	defer n.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("[Discovery] read failed", err)
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(buf[:size], &msg); err != nil {
+			log.Println("[Discovery] bad message", err)
+			continue
+		}
+		n.handle(&msg)
+	}
+}
+
+func (n *Node) handle(msg *message) {
+	for _, m := range msg.Updates {
+		n.apply(m)
+	}
+	switch msg.Type {
+	case msgPing:
+		n.send(msg.From, &message{Type: msgAck, Seq: msg.Seq, From: n.self})
+	case msgPingReq:
+		//帮忙探测Target，收到ack之后转发给请求方
+		from, seq := msg.From, msg.Seq
+		n.probeFor(msg.Target, func() {
+			n.send(from, &message{Type: msgAck, Seq: seq, From: n.self})
+		})
+	case msgAck:
+		n.mu.Lock()
+		fn := n.acks[msg.Seq]
+		delete(n.acks, msg.Seq)
+		n.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	case msgJoin:
+		n.mu.Lock()
+		all := make([]Member, 0, len(n.members))
+		for _, m := range n.members {
+			all = append(all, *m)
+		}
+		n.mu.Unlock()
+		n.send(msg.From, &message{Type: msgJoinSync, From: n.self, Updates: all})
+	case msgJoinSync:
+		n.mu.Lock()
+		n.joined = true
+		n.mu.Unlock()
+	}
+}
+
+// 发送ping给target，收到ack之后调用fn，超时之后fn不会再被调用
+func (n *Node) probeFor(target string, fn func()) {
+	n.mu.Lock()
+	n.seq++
+	seq := n.seq
+	n.acks[seq] = fn
+	n.mu.Unlock()
+	time.AfterFunc(n.cfg.ProbeInterval, func() {
+		n.mu.Lock()
+		delete(n.acks, seq)
+		n.mu.Unlock()
+	})
+	n.sendPing(target, seq)
+}
+
+func (n *Node) sendPing(target string, seq uint64) {
+	n.send(target, &message{Type: msgPing, Seq: seq, From: n.self})
+}
+
+// 发送消息，会尽量捎带一些等待广播的状态变化
+func (n *Node) send(to string, msg *message) {
+	addr, err := net.ResolveUDPAddr("udp", to)
+	if err != nil {
+		log.Println("[Discovery] bad address", to, err)
+		return
+	}
+	if msg.Type != msgJoinSync {
+		msg.Updates = append(msg.Updates, n.takeBroadcasts()...)
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if _, err := n.conn.WriteToUDP(b, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Println("[Discovery] send failed", err)
+	}
+}
+
+// 取出一批需要捎带的状态变化，每一条被捎带足够多次之后就不再传播
+func (n *Node) takeBroadcasts() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.broadcasts) == 0 {
+		return nil
+	}
+	limit := retransmitMult * int(math.Ceil(math.Log10(float64(len(n.members)+1))))
+	if limit < 1 {
+		limit = 1
+	}
+	//优先发送被传播次数少的
+	sort.SliceStable(n.broadcasts, func(i, j int) bool {
+		return n.broadcasts[i].transmits < n.broadcasts[j].transmits
+	})
+	var updates []Member
+	size := 0
+	kept := n.broadcasts[:0]
+	for _, b := range n.broadcasts {
+		if len(updates) < maxPiggyback && size < maxPacketSize {
+			updates = append(updates, b.member)
+			size += len(b.member.Addr) + len(b.member.Meta) + 32
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	n.broadcasts = kept
+	return updates
+}
+
+// 同一个结点只保留最新的一条状态变化
+func (n *Node) queueBroadcastLocked(m Member) {
+	for _, b := range n.broadcasts {
+		if b.member.Addr == m.Addr {
+			b.member = m
+			b.transmits = 0
+			return
+		}
+	}
+	n.broadcasts = append(n.broadcasts, &broadcast{member: m})
+}
+
+// 处理一条收到的状态变化
+func (n *Node) apply(m Member) {
+	n.mu.Lock()
+	var added, removed string
+	defer func() {
+		n.mu.Unlock()
+		//通知的时候不持有锁，防止Membership回调里面再调用Node的方法
+		if n.cfg.Membership == nil {
+			return
+		}
+		if added != "" {
+			n.cfg.Membership.AddPeers(added)
+		}
+		if removed != "" {
+			n.cfg.Membership.RemovePeers(removed)
+		}
+	}()
+
+	if m.Addr == n.self {
+		//别人怀疑自己或者认为自己已经挂了，那么就增加incarnation反驳
+		if m.State != StateAlive && m.Incarnation >= n.incarnation {
+			self := n.members[n.self]
+			if self.State == StateDead { //自己主动离开了，不需要反驳
+				return
+			}
+			n.incarnation = m.Incarnation + 1
+			self.Incarnation = n.incarnation
+			n.queueBroadcastLocked(*self)
+		}
+		return
+	}
+
+	old, ok := n.members[m.Addr]
+	if !ok {
+		if m.State == StateDead {
+			return
+		}
+		cp := m
+		n.members[m.Addr] = &cp
+		n.joined = true
+		n.queueBroadcastLocked(m)
+		added = m.Meta
+		if m.State == StateSuspect {
+			n.startSuspicionLocked(m.Addr, m.Incarnation)
+		}
+		return
+	}
+	if !overrides(m, *old) {
+		return
+	}
+	wasDead := old.State == StateDead
+	*old = m
+	n.queueBroadcastLocked(m)
+	switch m.State {
+	case StateAlive:
+		if t := n.suspicions[m.Addr]; t != nil {
+			t.Stop()
+			delete(n.suspicions, m.Addr)
+		}
+		if wasDead {
+			added = m.Meta
+		}
+	case StateSuspect:
+		if wasDead {
+			added = m.Meta
+		}
+		n.startSuspicionLocked(m.Addr, m.Incarnation)
+	case StateDead:
+		if t := n.suspicions[m.Addr]; t != nil {
+			t.Stop()
+			delete(n.suspicions, m.Addr)
+		}
+		if !wasDead {
+			removed = m.Meta
+		}
+	}
+}
+
+// 判断新的状态是否可以覆盖旧的状态，规则和SWIM论文一致：
+// alive只能被更大的incarnation覆盖，suspect以及dead可以覆盖同一个incarnation的alive
+func overrides(m, old Member) bool {
+	switch m.State {
+	case StateAlive:
+		return m.Incarnation > old.Incarnation
+	case StateSuspect:
+		if old.State == StateAlive {
+			return m.Incarnation >= old.Incarnation
+		}
+		return m.Incarnation > old.Incarnation
+	case StateDead:
+		if old.State == StateDead {
+			return false
+		}
+		return m.Incarnation >= old.Incarnation
+	}
+	return false
+}
+
+// 开始怀疑某一个结点，超时之后还没有被反驳就认定为dead
+func (n *Node) startSuspicionLocked(addr string, incarnation uint64) {
+	if _, ok := n.suspicions[addr]; ok {
+		return
+	}
+	n.suspicions[addr] = time.AfterFunc(n.cfg.SuspicionTimeout, func() {
+		n.mu.Lock()
+		m, ok := n.members[addr]
+		if !ok || m.State != StateSuspect || m.Incarnation != incarnation {
+			delete(n.suspicions, addr)
+			n.mu.Unlock()
+			return
+		}
+		dead := *m
+		dead.State = StateDead
+		delete(n.suspicions, addr)
+		n.mu.Unlock()
+		log.Println("[Discovery] member dead", addr)
+		n.apply(dead)
+	})
+}
+
+// 返回除自己以外所有没有dead的结点
+func (n *Node) aliveLocked() []string {
+	var addrs []string
+	for addr, m := range n.members {
+		if addr != n.self && m.State != StateDead {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.rejoin()
+			n.probe()
+		}
+	}
+}
+
+// 挑选下一个结点进行探测
+func (n *Node) nextTarget() (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		if len(n.probeOrder) == 0 {
+			n.probeOrder = n.aliveLocked()
+			if len(n.probeOrder) == 0 {
+				return "", false
+			}
+			rand.Shuffle(len(n.probeOrder), func(i, j int) {
+				n.probeOrder[i], n.probeOrder[j] = n.probeOrder[j], n.probeOrder[i]
+			})
+		}
+		target := n.probeOrder[0]
+		n.probeOrder = n.probeOrder[1:]
+		if m, ok := n.members[target]; ok && m.State != StateDead {
+			return target, true
+		}
+	}
+}
+
+// 一轮探测：直接ping，超时之后间接ping，一轮结束之后还没有ack就标记为suspect
+func (n *Node) probe() {
+	target, ok := n.nextTarget()
+	if !ok {
+		return
+	}
+	acked := make(chan struct{})
+	var once sync.Once
+	onAck := func() { once.Do(func() { close(acked) }) }
+
+	n.probeFor(target, onAck)
+	select {
+	case <-acked:
+		return
+	case <-n.stop:
+		return
+	case <-time.After(n.cfg.ProbeTimeout):
+	}
+
+	//间接探测
+	n.mu.Lock()
+	var helpers []string
+	for _, addr := range n.aliveLocked() {
+		if addr != target {
+			helpers = append(helpers, addr)
+		}
+	}
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	if len(helpers) > n.cfg.IndirectChecks {
+		helpers = helpers[:n.cfg.IndirectChecks]
+	}
+	n.seq++
+	seq := n.seq
+	n.acks[seq] = onAck
+	n.mu.Unlock()
+	for _, helper := range helpers {
+		n.send(helper, &message{Type: msgPingReq, Seq: seq, From: n.self, Target: target})
+	}
+	select {
+	case <-acked:
+	case <-n.stop:
+	case <-time.After(n.cfg.ProbeInterval - n.cfg.ProbeTimeout):
+	}
+	n.mu.Lock()
+	delete(n.acks, seq)
+	m, ok := n.members[target]
+	var suspect Member
+	if ok {
+		suspect = *m
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-acked:
+		return
+	default:
+	}
+	if !ok || suspect.State != StateAlive {
+		return
+	}
+	log.Println("[Discovery] suspect member", target)
+	suspect.State = StateSuspect
+	n.apply(suspect)
+}
+
+func (m Member) String() string {
+	return fmt.Sprintf("%s(%s,%s,%d)", m.Addr, m.Meta, m.State, m.Incarnation)
+}
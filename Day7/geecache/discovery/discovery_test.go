@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"awesomeProject2/Day7/geecache"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// HTTPPool可以直接作为Membership使用
+var _ Membership = (*geecache.HTTPPool)(nil)
+
+// 记录成员变化，用来代替HTTPPool
+type fakeMembership struct {
+	mu    sync.Mutex
+	peers map[string]bool
+}
+
+func newFakeMembership() *fakeMembership {
+	return &fakeMembership{peers: make(map[string]bool)}
+}
+
+func (f *fakeMembership) AddPeers(peers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range peers {
+		f.peers[p] = true
+	}
+}
+
+func (f *fakeMembership) RemovePeers(peers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range peers {
+		delete(f.peers, p)
+	}
+}
+
+func (f *fakeMembership) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var peers []string
+	for p := range f.peers {
+		peers = append(peers, p)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func startNode(t *testing.T, meta string, seeds ...string) (*Node, *fakeMembership) {
+	t.Helper()
+	m := newFakeMembership()
+	n, err := Start(Config{
+		BindAddr:         "127.0.0.1:0",
+		Meta:             meta,
+		Seeds:            seeds,
+		ProbeInterval:    20 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		Membership:       m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n, m
+}
+
+// 在timeout之内等待cond成立
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJoinAndFailureDetection(t *testing.T) {
+	a, ma := startNode(t, "http://a")
+	b, mb := startNode(t, "http://b", a.Addr())
+	c, mc := startNode(t, "http://c", a.Addr())
+	all := []string{"http://a", "http://b", "http://c"}
+	//b和c都只知道a，但是通过gossip可以互相发现
+	for _, m := range []*fakeMembership{ma, mb, mc} {
+		m := m
+		waitFor(t, 2*time.Second, func() bool { return equal(m.list(), all) }, "members should converge")
+	}
+	if len(b.Members()) != 3 {
+		t.Fatalf("b should know 3 members, but %v got", b.Members())
+	}
+	//c直接挂掉，不通知任何结点
+	c.Close()
+	for _, m := range []*fakeMembership{ma, mb} {
+		m := m
+		waitFor(t, 2*time.Second, func() bool { return equal(m.list(), all[:2]) }, "dead member should be removed")
+	}
+}
+
+func TestJoinBeforeSeed(t *testing.T) {
+	//先拿到一个空闲的端口，种子结点之后再在这个端口上启动
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedAddr := conn.LocalAddr().String()
+	conn.Close()
+
+	_, mb := startNode(t, "http://b", seedAddr)
+	time.Sleep(50 * time.Millisecond) //第一次的加入请求已经丢失了
+	ma := newFakeMembership()
+	a, err := Start(Config{
+		BindAddr:      seedAddr,
+		Meta:          "http://a",
+		ProbeInterval: 20 * time.Millisecond,
+		Membership:    ma,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	all := []string{"http://a", "http://b"}
+	for _, m := range []*fakeMembership{ma, mb} {
+		m := m
+		waitFor(t, 2*time.Second, func() bool { return equal(m.list(), all) }, "b should join after the seed starts")
+	}
+}
+
+func TestLeave(t *testing.T) {
+	a, ma := startNode(t, "http://a")
+	b, _ := startNode(t, "http://b", a.Addr())
+	waitFor(t, 2*time.Second, func() bool { return len(ma.list()) == 2 }, "b should join")
+	b.Leave(10 * time.Millisecond)
+	//主动离开不需要等待故障检测
+	waitFor(t, 50*time.Millisecond, func() bool { return equal(ma.list(), []string{"http://a"}) }, "b should leave")
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	a, _ := startNode(t, "http://a")
+	b, _ := startNode(t, "http://b", a.Addr())
+	waitFor(t, 2*time.Second, func() bool { return len(a.Members()) == 2 }, "b should join")
+	a.mu.Lock()
+	old := *a.members[b.Addr()]
+	a.mu.Unlock()
+	//a错误地怀疑b，b收到之后需要用更大的incarnation反驳
+	suspect := old
+	suspect.State = StateSuspect
+	a.apply(suspect)
+	waitFor(t, 2*time.Second, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		m := a.members[b.Addr()]
+		return m.State == StateAlive && m.Incarnation > old.Incarnation
+	}, "b should refute the suspicion")
+}
+
+func TestOverrides(t *testing.T) {
+	testCases := []struct {
+		m, old Member
+		expect bool
+	}{
+		{Member{State: StateAlive, Incarnation: 2}, Member{State: StateSuspect, Incarnation: 1}, true},
+		{Member{State: StateAlive, Incarnation: 1}, Member{State: StateSuspect, Incarnation: 1}, false},
+		{Member{State: StateSuspect, Incarnation: 1}, Member{State: StateAlive, Incarnation: 1}, true},
+		{Member{State: StateSuspect, Incarnation: 1}, Member{State: StateSuspect, Incarnation: 1}, false},
+		{Member{State: StateDead, Incarnation: 1}, Member{State: StateSuspect, Incarnation: 1}, true},
+		{Member{State: StateDead, Incarnation: 0}, Member{State: StateAlive, Incarnation: 1}, false},
+		{Member{State: StateAlive, Incarnation: 2}, Member{State: StateDead, Incarnation: 1}, true},
+	}
+	for _, tc := range testCases {
+		if got := overrides(tc.m, tc.old); got != tc.expect {
+			t.Errorf("overrides(%v, %v) = %v, expect %v", tc.m, tc.old, got, tc.expect)
+		}
+	}
+}
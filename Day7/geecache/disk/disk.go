@@ -0,0 +1,412 @@
+package disk
+
+// 一个简单的嵌入式磁盘存储，作为内存缓存后面的第二层
+// 1.所有的写入（包括删除）都只追加到一个日志文件的末尾，内存中保存每一个key在文件中的位置（索引）
+// 2.重启的时候从头扫描一遍日志文件重建索引，末尾写了一半的记录会被截掉
+// 3.有效数据超过字节数上限之后，最早写入的key会被淘汰
+// 4.被覆盖、删除、淘汰的记录就成了垃圾，垃圾太多的时候把有效的记录重写到一个新文件中（压缩）
+//
+// 每一条记录的格式（小端序）：
+//
+//	crc32(4) | flags(1) | expire(8) | keyLen(4) | valueLen(4) | key | value
+//
+// crc32校验的是crc32之后的所有字节
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logName           = "data.log"
+	headerSize        = 4 + 1 + 8 + 4 + 4
+	flagTombstone     = 1       //表示这个key被删除了
+	minCompactGarbage = 1 << 20 //垃圾至少有这么多字节才会自动压缩
+)
+
+// key不存在或者已经过期
+var ErrNotFound = errors.New("disk: key not found")
+
+// 索引中的一项，记录了一个key最新的记录在文件中的位置
+type item struct {
+	key      string
+	offset   int64 //记录的起始位置
+	valueLen int
+	expire   time.Time
+	elem     *list.Element //在写入顺序链表中的位置，用来淘汰最早写入的key
+}
+
+// 记录的总长度
+func (it *item) size() int64 {
+	return int64(headerSize + len(it.key) + it.valueLen)
+}
+
+// 有效数据的长度，和内存缓存一样只算key和value
+func (it *item) bytes() int64 {
+	return int64(len(it.key) + it.valueLen)
+}
+
+type Store struct {
+	dir      string
+	maxBytes int64 //有效数据的字节数上限，0表示没有限制
+	mu       sync.RWMutex
+	f        *os.File
+	size     int64 //文件的大小，也就是下一条记录写入的位置
+	live     int64 //有效数据的字节数
+	garbage  int64 //垃圾记录占用的字节数
+	index    map[string]*item
+	ll       *list.List //按照写入顺序排列，最前面的是最早写入的
+}
+
+// 打开dir中的存储，目录不存在的时候会自动创建，
+// 一个目录只能被一个Store使用
+func Open(dir string, maxBytes int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:      dir,
+		maxBytes: maxBytes,
+		f:        f,
+		index:    make(map[string]*item),
+		ll:       list.New(),
+	}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	//上限可能比上一次打开的时候小
+	if err := s.evict(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// 从头扫描日志文件，重建索引
+func (s *Store) load() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	r := &offsetReader{r: bufio.NewReader(s.f)}
+	for {
+		offset := r.n
+		rec, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			//最后一条记录没有写完就崩溃了，把它截掉，后面的写入从这里继续
+			if err := s.f.Truncate(offset); err != nil {
+				return err
+			}
+			r.n = offset
+			break
+		}
+		size := int64(headerSize + len(rec.key) + len(rec.value))
+		if old, ok := s.index[rec.key]; ok {
+			s.drop(old)
+		}
+		if rec.flags&flagTombstone != 0 {
+			s.garbage += size
+			continue
+		}
+		s.add(&item{key: rec.key, offset: offset, valueLen: len(rec.value), expire: rec.expire})
+	}
+	s.size = r.n
+	_, err = s.f.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// 把一个item加入索引
+func (s *Store) add(it *item) {
+	it.elem = s.ll.PushBack(it)
+	s.index[it.key] = it
+	s.live += it.bytes()
+}
+
+// 把一个item从索引中去掉，它的记录变成了垃圾
+func (s *Store) drop(it *item) {
+	s.ll.Remove(it.elem)
+	delete(s.index, it.key)
+	s.live -= it.bytes()
+	s.garbage += it.size()
+}
+
+// 写入一个key，expire为零值表示永不过期
+// 超过字节数上限的值不会被写入
+func (s *Store) Put(key string, value []byte, expire time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && int64(len(key)+len(value)) > s.maxBytes {
+		//存不下新的值，旧的值也不能再留着
+		if old, ok := s.index[key]; ok {
+			return s.remove(old)
+		}
+		return nil
+	}
+	offset, err := s.append(0, key, value, expire)
+	if err != nil {
+		return err
+	}
+	if old, ok := s.index[key]; ok {
+		s.drop(old)
+	}
+	s.add(&item{key: key, offset: offset, valueLen: len(value), expire: expire})
+	if err := s.evict(); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// 读取一个key，不存在或者已经过期时返回ErrNotFound
+func (s *Store) Get(key string) (value []byte, expire time.Time, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it, ok := s.index[key]
+	if !ok || (!it.expire.IsZero() && !time.Now().Before(it.expire)) {
+		return nil, time.Time{}, ErrNotFound
+	}
+	buf := make([]byte, it.size())
+	if _, err := s.f.ReadAt(buf, it.offset); err != nil {
+		return nil, time.Time{}, err
+	}
+	rec, err := decodeRecord(buf)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return rec.value, it.expire, nil
+}
+
+// 删除一个key，不存在的key什么都不做
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.index[key]
+	if !ok {
+		return nil
+	}
+	return s.remove(it)
+}
+
+// 写入一个删除的记录，这样重启之后这个key也不会再出现
+func (s *Store) remove(it *item) error {
+	if _, err := s.append(flagTombstone, it.key, nil, time.Time{}); err != nil {
+		return err
+	}
+	s.drop(it)
+	s.garbage += int64(headerSize + len(it.key))
+	return nil
+}
+
+// 有效数据超过上限时，淘汰最早写入的key
+func (s *Store) evict() error {
+	for s.maxBytes > 0 && s.live > s.maxBytes {
+		if err := s.remove(s.ll.Front().Value.(*item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在文件末尾追加一条记录，返回记录的起始位置
+func (s *Store) append(flags byte, key string, value []byte, expire time.Time) (int64, error) {
+	buf := encodeRecord(flags, key, value, expire)
+	if _, err := s.f.Write(buf); err != nil {
+		return 0, err
+	}
+	offset := s.size
+	s.size += int64(len(buf))
+	return offset, nil
+}
+
+// 垃圾比有效的记录还多的时候压缩
+func (s *Store) maybeCompact() error {
+	if s.garbage < minCompactGarbage || s.garbage < s.size-s.garbage {
+		return nil
+	}
+	return s.compact()
+}
+
+// 把所有有效并且没有过期的记录重写到一个新文件中，然后替换掉原来的文件
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *Store) compact() error {
+	path := filepath.Join(s.dir, logName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var (
+		size    int64
+		offsets = make(map[*item]int64, len(s.index))
+	)
+	for e := s.ll.Front(); e != nil; e = e.Next() {
+		it := e.Value.(*item)
+		if !it.expire.IsZero() && !now.Before(it.expire) {
+			continue
+		}
+		buf := make([]byte, it.size())
+		if _, err = s.f.ReadAt(buf, it.offset); err != nil {
+			break
+		}
+		if _, err = tmp.Write(buf); err != nil {
+			break
+		}
+		offsets[it] = size
+		size += int64(len(buf))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.f.Close()
+	s.f = tmp
+	s.size, s.garbage = size, 0
+	//过期的key在新文件中已经没有了
+	for e := s.ll.Front(); e != nil; {
+		next := e.Next()
+		it := e.Value.(*item)
+		if offset, ok := offsets[it]; ok {
+			it.offset = offset
+		} else {
+			s.ll.Remove(e)
+			delete(s.index, it.key)
+			s.live -= it.bytes()
+		}
+		e = next
+	}
+	return nil
+}
+
+// 有效数据的字节数
+func (s *Store) Bytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.live
+}
+
+// key的个数，包括已经过期但是还没有被清理的
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+// 日志文件的大小，包括垃圾记录
+func (s *Store) FileSize() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+type record struct {
+	flags  byte
+	expire time.Time
+	key    string
+	value  []byte
+}
+
+func encodeRecord(flags byte, key string, value []byte, expire time.Time) []byte {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = flags
+	var nano int64
+	if !expire.IsZero() {
+		nano = expire.UnixNano()
+	}
+	binary.LittleEndian.PutUint64(buf[5:], uint64(nano))
+	binary.LittleEndian.PutUint32(buf[13:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[17:], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// 解析一条完整的记录，buf的长度必须刚好是一条记录
+func decodeRecord(buf []byte) (*record, error) {
+	if crc32.ChecksumIEEE(buf[4:]) != binary.LittleEndian.Uint32(buf) {
+		return nil, fmt.Errorf("disk: checksum mismatch")
+	}
+	keyLen := int(binary.LittleEndian.Uint32(buf[13:]))
+	rec := &record{
+		flags: buf[4],
+		key:   string(buf[headerSize : headerSize+keyLen]),
+		value: buf[headerSize+keyLen:],
+	}
+	if nano := int64(binary.LittleEndian.Uint64(buf[5:])); nano != 0 {
+		rec.expire = time.Unix(0, nano)
+	}
+	return rec, nil
+}
+
+// 从r中读取一条记录，刚好读到文件末尾时返回io.EOF，
+// remaining是文件剩下的字节数，防止长度被写坏的时候分配一块巨大的内存
+func readRecord(r io.Reader, remaining int64) (*record, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("disk: truncated record")
+		}
+		return nil, err
+	}
+	keyLen := binary.LittleEndian.Uint32(header[13:])
+	valueLen := binary.LittleEndian.Uint32(header[17:])
+	if int64(headerSize)+int64(keyLen)+int64(valueLen) > remaining {
+		return nil, fmt.Errorf("disk: truncated record")
+	}
+	buf := make([]byte, headerSize+int(keyLen)+int(valueLen))
+	copy(buf, header)
+	if _, err := io.ReadFull(r, buf[headerSize:]); err != nil {
+		return nil, fmt.Errorf("disk: truncated record")
+	}
+	return decodeRecord(buf)
+}
+
+// 记录读取了多少字节，用来得到每一条记录的位置
+type offsetReader struct {
+	r io.Reader
+	n int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.n += int64(n)
+	return n, err
+}
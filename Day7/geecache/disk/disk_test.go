@@ -0,0 +1,143 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutGetDelete(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Put("Tom", []byte("630"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Put("Tom", []byte("631"), time.Time{})
+	if v, _, err := s.Get("Tom"); err != nil || string(v) != "631" {
+		t.Fatalf("get Tom failed, value %q, err %v", v, err)
+	}
+	if _, _, err := s.Get("Jack"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, but %v got", err)
+	}
+	s.Put("short", []byte("v"), time.Now().Add(-time.Second))
+	if _, _, err := s.Get("short"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expired key should not be returned")
+	}
+	s.Delete("Tom")
+	if _, _, err := s.Get("Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("Tom should be deleted")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire := time.Now().Add(time.Hour)
+	s.Put("Tom", []byte("630"), expire)
+	s.Put("Jack", []byte("589"), time.Time{})
+	s.Put("Sam", []byte("567"), time.Time{})
+	s.Delete("Sam")
+	s.Close()
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, e, err := s.Get("Tom"); err != nil || string(v) != "630" || !e.Equal(expire) {
+		t.Fatalf("get Tom failed, value %q, expire %v, err %v", v, e, err)
+	}
+	if v, _, err := s.Get("Jack"); err != nil || string(v) != "589" {
+		t.Fatalf("get Jack failed, value %q, err %v", v, err)
+	}
+	if _, _, err := s.Get("Sam"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("deleted Sam should not come back after reopen")
+	}
+}
+
+func TestTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	s.Put("Tom", []byte("630"), time.Time{})
+	s.Put("Jack", []byte("589"), time.Time{})
+	size := s.FileSize()
+	s.Close()
+	//模拟写最后一条记录的时候崩溃了
+	path := filepath.Join(dir, logName)
+	if err := os.Truncate(path, size-2); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, err := s.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("Jack"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("truncated Jack should be dropped")
+	}
+	//截掉之后还可以继续写入
+	s.Put("Sam", []byte("567"), time.Time{})
+	if v, _, err := s.Get("Sam"); err != nil || string(v) != "567" {
+		t.Fatalf("get Sam failed, value %q, err %v", v, err)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	s, _ := Open(t.TempDir(), 20)
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		s.Put(fmt.Sprintf("key%d", i), []byte("value"), time.Time{}) //每一个8字节
+	}
+	if s.Bytes() > 20 || s.Len() != 2 {
+		t.Fatalf("unexpected bytes %d len %d", s.Bytes(), s.Len())
+	}
+	//最早写入的被淘汰
+	if _, _, err := s.Get("key0"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("key0 should be evicted")
+	}
+	if _, _, err := s.Get("key4"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 0)
+	for i := 0; i < 100; i++ {
+		s.Put("Tom", []byte(fmt.Sprintf("%d", i)), time.Time{})
+	}
+	s.Put("Jack", []byte("589"), time.Time{})
+	s.Put("short", []byte("v"), time.Now().Add(-time.Second))
+	before := s.FileSize()
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.FileSize() >= before || s.Len() != 2 {
+		t.Fatalf("file should shrink, before %d after %d, len %d", before, s.FileSize(), s.Len())
+	}
+	if v, _, err := s.Get("Tom"); err != nil || string(v) != "99" {
+		t.Fatalf("get Tom failed, value %q, err %v", v, err)
+	}
+	s.Put("Sam", []byte("567"), time.Time{})
+	s.Close()
+
+	s, _ = Open(dir, 0)
+	defer s.Close()
+	for key, want := range map[string]string{"Tom": "99", "Jack": "589", "Sam": "567"} {
+		if v, _, err := s.Get(key); err != nil || string(v) != want {
+			t.Fatalf("get %s failed after compact, value %q, err %v", key, v, err)
+		}
+	}
+}
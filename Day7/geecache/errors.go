@@ -0,0 +1,57 @@
+package geecache
+
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"errors"
+	"fmt"
+)
+
+// 远程结点返回的错误会被转换成下面这些错误，调用方可以使用errors.Is判断是哪一种
+var (
+	// key不存在，Getter在数据库中找不到key的时候应该返回它（或者用%w包装它），
+	// 这样开启负缓存之后就会被缓存起来，远程结点也会把它原样传回来
+	ErrNotFound = errors.New("geecache: key not found")
+	// 远程结点上没有这个组，一般是各个结点创建的组不一致
+	ErrGroupNotFound = errors.New("geecache: group not found")
+	// 远程结点的Getter加载失败了
+	ErrLoadFailed = errors.New("geecache: load failed")
+	// 远程结点正在处理的请求太多，拒绝了这个请求
+	ErrOverloaded = errors.New("geecache: peer overloaded")
+)
+
+// 把错误转换成对应的错误码，不认识的错误都当成加载失败
+func codeOf(err error) pb.Code {
+	switch {
+	case err == nil:
+		return pb.Code_OK
+	case errors.Is(err, ErrNotFound):
+		return pb.Code_NOT_FOUND
+	case errors.Is(err, ErrGroupNotFound):
+		return pb.Code_GROUP_NOT_FOUND
+	case errors.Is(err, ErrOverloaded):
+		return pb.Code_OVERLOADED
+	default:
+		return pb.Code_LOAD_FAILED
+	}
+}
+
+// 把远程结点返回的错误码转换回错误，message是远程结点的错误信息
+func errorOf(code pb.Code, message string) error {
+	var sentinel error
+	switch code {
+	case pb.Code_OK:
+		return nil
+	case pb.Code_NOT_FOUND:
+		sentinel = ErrNotFound
+	case pb.Code_GROUP_NOT_FOUND:
+		sentinel = ErrGroupNotFound
+	case pb.Code_OVERLOADED:
+		sentinel = ErrOverloaded
+	default:
+		sentinel = ErrLoadFailed
+	}
+	if message == "" || message == sentinel.Error() {
+		return sentinel
+	}
+	return fmt.Errorf("%w: %s", sentinel, message)
+}
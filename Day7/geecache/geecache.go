@@ -1,22 +1,40 @@
 package geecache
 
 import (
+	"awesomeProject2/Day7/geecache/LRU"
+	"awesomeProject2/Day7/geecache/disk"
 	pb "awesomeProject2/Day7/geecache/geecachepb"
 	"awesomeProject2/Day7/geecache/singleflight"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Group 是 GeeCache 最核心的数据结构，负责与用户的交互，并且控制缓存值存储和获取的流程
 type Group struct {
 	name      string
 	getter    Getter
-	mainCache cache
+	mainCache cacheStore
+	//hotCache 保存的是本应属于其他结点的key，但是访问的很频繁，固然在本地也存一份，
+	//避免每次都需要通过网络请求其他结点，它比mainCache要小很多
+	hotCache           cacheStore
+	hotCacheSampleRate float64 //从远程结点拿到的值有多大的概率放入hotCache
+	//negCache 记录最近查询过但是不存在的key，为nil表示没有开启负缓存
+	negCache cacheStore
 	//这里并没有实现对应的接口函数，在go中，只需要保证使用这个对象的时候，里面的接口被定义了1就行
 	//但是c++需要编译时检查，固然需要一开始就实现
 	peers  PeerPicker
 	loader *singleflight.Group
+	stats  Stats        //统计信息
+	opts   cacheOptions //创建缓存时使用的配置
+	//关闭之后后台清理协程退出
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // 定义了一个回调函数的接口
@@ -33,6 +51,67 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// 可选的接口，Getter如果同时实现了这个接口，那么就可以为每一个key返回一个过期时间
+// ttl小于等于0表示永不过期
+type GetterWithTTL interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// 和GetterFunc类似，方便直接用一个函数来实现Getter以及GetterWithTTL
+type GetterWithTTLFunc func(key string) ([]byte, time.Duration, error)
+
+func (f GetterWithTTLFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+func (f GetterWithTTLFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// 可选的接口，Getter如果同时实现了这个接口，那么调用方的context就会传递进来，
+// 调用方取消请求或者超时之后，就可以及时停止查询数据库
+type GetterWithContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// 和GetterFunc类似，方便直接用一个函数来实现Getter以及GetterWithContext
+type GetterWithContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f GetterWithContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f GetterWithContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// 可选的接口，同时需要context以及过期时间的Getter实现这个，它会被优先使用
+type GetterWithContextTTL interface {
+	GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// 和GetterFunc类似，方便直接用一个函数来实现Getter、GetterWithContext、GetterWithTTL以及GetterWithContextTTL
+type GetterWithContextTTLFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+func (f GetterWithContextTTLFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
+func (f GetterWithContextTTLFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	bytes, _, err := f(ctx, key)
+	return bytes, err
+}
+
+func (f GetterWithContextTTLFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(context.Background(), key)
+}
+
+func (f GetterWithContextTTLFunc) GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
 // 建立多个缓存结构，这样可以实现缓存多种数据类型
 // 全局声明需要放到最外面
 var (
@@ -41,22 +120,74 @@ var (
 )
 
 // 创建一个新的类型的缓存结构,传入了一个接口
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:               name,
+		getter:             getter,
+		hotCacheSampleRate: defaultHotCacheSampleRate,
+		loader:             &singleflight.Group{},
+		done:               make(chan struct{}),
+		opts: cacheOptions{
+			cacheBytes:    cacheBytes,
+			hotCacheRatio: defaultHotCacheRatio,
+		},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	var spill spillFunc
+	if g.opts.disk != nil {
+		spill = g.spillToDisk
+	}
+	//只有mainCache中的值才属于这个结点，hotCache中的值不需要写到磁盘上
+	g.mainCache = newCacheStore(g.opts.cacheBytes, g.opts.policy, g.opts.shards, spill)
+	g.hotCache = newCacheStore(int64(float64(g.opts.cacheBytes)*g.opts.hotCacheRatio), g.opts.policy, g.opts.shards, nil)
+	if g.opts.negativeTTL > 0 {
+		g.negCache = newCacheStore(int64(float64(g.opts.cacheBytes)*defaultNegativeCacheRatio), LRU.PolicyLRU, 1, nil)
 	}
 	groups[name] = g
+	go g.sweeper(defaultSweepInterval)
 	return g
 }
 
+// 后台定期清理所有缓存中过期的值，Get中的惰性删除只能删除被访问到的key，
+// 没人访问的过期key就需要靠这个协程来清理，否则会一直占着内存。
+// 每一个组只有这一个协程，不管有多少个分片，Close之后退出
+func (g *Group) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.removeExpired()
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// 删除所有缓存中已经过期的值，返回删除的个数
+func (g *Group) removeExpired() int {
+	n := g.mainCache.removeExpired() + g.hotCache.removeExpired()
+	if g.negCache != nil {
+		n += g.negCache.removeExpired()
+	}
+	return n
+}
+
+// 停止这个组的后台清理协程，可以调用多次。
+// 之后组依旧可以使用，只是过期的值只有在被访问到的时候才会被删除
+func (g *Group) Close() {
+	g.closeOnce.Do(func() {
+		close(g.done)
+	})
+}
+
 // GetGroup 返回先前使用 NewGroup 创建的命名组，或者
 // 如果不存在这样的组，则返回 nil。
 func GetGroup(name string) *Group {
@@ -66,39 +197,338 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// 返回所有的组，按照名字排序
+func Groups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		all = append(all, g)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all
+}
+
+// 返回组的名字
+func (g *Group) Name() string {
+	return g.name
+}
+
+// 返回对应缓存的最大字节数，0表示没有限制
+func (g *Group) CacheBytes(which CacheType) int64 {
+	switch which {
+	case MainCache:
+		return g.mainCache.maxBytes()
+	case HotCache:
+		return g.hotCache.maxBytes()
+	default:
+		return 0
+	}
+}
+
 // 从这个缓存组中拿取对应之前缓存过的内容
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// 和Get一样，只不过ctx会一直传递到Getter以及远程结点的请求中，
+// ctx被取消或者超时之后，就不会再继续去远程结点或者数据库中加载
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	//必须含有key
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
-
-	if v, ok := g.mainCache.get(key); ok {
+	g.stats.Gets.Add(1)
+	if v, ok := g.lookupCache(key); ok {
+		g.stats.CacheHits.Add(1)
 		log.Println("[GeeCache] hit")
 		return v, nil
 	}
+	if g.negCache != nil {
+		if _, ok := g.negCache.get(key); ok {
+			g.stats.NegativeHits.Add(1)
+			return ByteView{}, ErrNotFound
+		}
+	}
 	//如果没有这个对应的缓存，那么就从Lru里面内部拿取（即可以理解为磁盘中拿取）
-	return g.load(key)
+	return g.load(ctx, key)
+}
+
+// 先查找mainCache，再查找hotCache
+func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
+	if value, ok = g.mainCache.get(key); ok {
+		return
+	}
+	if g.hotCache.maxBytes() <= 0 {
+		return
+	}
+	return g.hotCache.get(key)
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
 	//调用回调函数,触发没有key缓存对应的回调函数
 	//这个回调函数挺关键的
-	bytes, err := g.getter.Get(key)
+	if value, ok := g.getFromDisk(key); ok {
+		return value, nil
+	}
+	var (
+		bytes []byte
+		ttl   time.Duration
+		err   error
+	)
+	if ctg, ok := g.getter.(GetterWithContextTTL); ok {
+		bytes, ttl, err = ctg.GetContextWithTTL(ctx, key)
+	} else if cg, ok := g.getter.(GetterWithContext); ok {
+		bytes, err = cg.GetContext(ctx, key)
+	} else if tg, ok := g.getter.(GetterWithTTL); ok {
+		bytes, ttl, err = tg.GetWithTTL(key)
+	} else {
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil {
+		g.stats.LocalLoadErrs.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegative(key)
+		}
 		return ByteView{}, err
 	}
+	g.stats.LocalLoads.Add(1)
 	value := ByteView{
 		b: cloneBytes(bytes),
 	}
 	//填充对应的缓存
-	g.populateCache(key, value)
+	value = g.populateCache(key, value, ttl)
+	g.populateReplicas(key, value)
 	return value, nil
 }
 
-// 填充对应的缓存
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// 从数据库加载之后，把值写到key的其他副本上，这样请求打到其他副本上时就不需要再查一次数据库，
+// 在后台进行，不影响这一次的请求，失败了只记录日志
+func (g *Group) populateReplicas(key string, value ByteView) {
+	replicas := g.pickReplicas(key)
+	if len(replicas) == 0 {
+		return
+	}
+	go func() {
+		if err := g.setOnPeers(replicas, key, value.b, value.expire); err != nil {
+			log.Println("[GeeCache] Failed to populate replicas", err)
+		}
+	}()
+}
+
+// key所在的除自己以外的所有副本，没有开启副本时返回nil
+func (g *Group) pickReplicas(key string) []PeerGetter {
+	if rp, ok := g.peers.(PeerReplicaPicker); ok {
+		return rp.PickReplicas(key)
+	}
+	return nil
+}
+
+// 把值写入这些远程结点，expire为零值表示永不过期，返回第一个错误
+func (g *Group) setOnPeers(peers []PeerGetter, key string, value []byte, expire time.Time) error {
+	var first error
+	for _, peer := range peers {
+		setter, ok := peer.(PeerSetter)
+		if !ok {
+			continue
+		}
+		if err := setter.Set(&pb.SetRequest{Group: g.name, Key: key, Value: value, Expire: unixNano(expire)}); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// 从磁盘中查找，找到之后放回mainCache，磁盘上的不删除，再次被淘汰的时候会被覆盖
+func (g *Group) getFromDisk(key string) (ByteView, bool) {
+	if g.opts.disk == nil {
+		return ByteView{}, false
+	}
+	bytes, expire, err := g.opts.disk.Get(key)
+	if err != nil {
+		if !errors.Is(err, disk.ErrNotFound) {
+			log.Println("[GeeCache] Failed to read from disk", err)
+		}
+		return ByteView{}, false
+	}
+	g.stats.DiskHits.Add(1)
+	value := ByteView{b: bytes, expire: expire}
+	g.mainCache.addWithExpiry(key, value, expire)
+	return value, true
+}
+
+// mainCache因为容量不足淘汰的值写到磁盘上，调用的时候已经释放了mainCache的锁
+func (g *Group) spillToDisk(key string, value ByteView, expire time.Time) {
+	if err := g.opts.disk.Put(key, value.b, expire); err != nil {
+		log.Println("[GeeCache] Failed to spill to disk", err)
+	}
+}
+
+// 删除磁盘上的旧值
+func (g *Group) removeFromDisk(key string) {
+	if g.opts.disk == nil {
+		return
+	}
+	if err := g.opts.disk.Delete(key); err != nil {
+		log.Println("[GeeCache] Failed to remove from disk", err)
+	}
+}
+
+// 填充对应的缓存,ttl小于等于0时使用value中已有的过期时间，返回带上过期时间的值
+func (g *Group) populateCache(key string, value ByteView, ttl time.Duration) ByteView {
+	if ttl > 0 {
+		value.expire = time.Now().Add(ttl)
+	}
+	g.mainCache.addWithExpiry(key, value, value.expire)
+	if g.negCache != nil {
+		g.negCache.remove(key)
+	}
+	return value
+}
+
+// 记录一个不存在的key，没有开启负缓存时什么都不做
+func (g *Group) populateNegative(key string) {
+	if g.negCache == nil {
+		return
+	}
+	g.negCache.addWithExpiry(key, ByteView{}, time.Now().Add(g.opts.negativeTTL))
+}
+
+// 删除一个key对应的缓存，除了删除本地的缓存之外，
+// 还会通知这个key真正所在的结点删除，并且广播给其他所有结点，防止其他结点还保存着这个key
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	if g.peers == nil {
+		return nil
+	}
+	var err error
+	//副本结点也会在广播的时候被通知，只跳过已经真正收到删除请求的结点
+	var skip []PeerGetter
+	if peer, ok := g.peers.PickPeer(key); ok {
+		skip = peersOf(peer) //下面广播的时候不需要再通知一次
+		err = g.removeFromPeer(peer, key)
+	}
+	g.broadcastRemove(key, skip...)
+	return err
+}
+
+// 一次请求实际会发送到的远程结点，replicaGetter会请求它里面的所有副本
+func peersOf(peer PeerGetter) []PeerGetter {
+	if r, ok := peer.(*replicaGetter); ok {
+		peers := make([]PeerGetter, len(r.getters))
+		for i, getter := range r.getters {
+			peers[i] = getter
+		}
+		return peers
+	}
+	return []PeerGetter{peer}
+}
+
+// 通知除了skip以外的所有远程结点删除key，广播只是尽力而为，失败了只记录日志
+func (g *Group) broadcastRemove(key string, skip ...PeerGetter) {
+	b, ok := g.peers.(PeerBroadcaster)
+	if !ok {
+		return
+	}
+	skipped := make(map[PeerGetter]bool, len(skip))
+	for _, peer := range skip {
+		skipped[peer] = true
+	}
+	var wg sync.WaitGroup
+	for _, peer := range b.AllPeers() {
+		if skipped[peer] {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := g.removeFromPeer(peer, key); err != nil {
+				log.Println("[GeeCache] Failed to broadcast remove", err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// 写入一个已经知道的新值，例如刚刚更新完数据库之后，这样就不需要再通过Getter加载一次。
+// 值会被写入key所在结点的mainCache，开启副本时写入所有的副本，其他结点hotCache中的旧值会被删除
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	var owner PeerGetter
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			setter, ok := peer.(PeerSetter)
+			if !ok {
+				return fmt.Errorf("peer does not support set")
+			}
+			if err := setter.Set(&pb.SetRequest{Group: g.name, Key: key, Value: value}); err != nil {
+				return err
+			}
+			owner = peer
+			g.removeLocally(key) //自己不是owner，本地可能还有旧值
+		}
+	}
+	if g.peers == nil {
+		g.setLocally(key, value, time.Time{})
+		return nil
+	}
+	//开启副本时，其他副本保存新值，不能再被下面的广播删除
+	replicas := g.pickReplicas(key)
+	var err error
+	if owner == nil {
+		g.setLocally(key, value, time.Time{})
+		err = g.setOnPeers(replicas, key, value, time.Time{})
+		g.broadcastRemove(key, replicas...)
+		return err
+	}
+	//自己是其他的副本时，PickPeer只返回排在自己前面的副本，后面的副本也需要写入新值
+	written := peersOf(owner)
+	skipped := make(map[PeerGetter]bool, len(written))
+	for _, peer := range written {
+		skipped[peer] = true
+	}
+	var rest []PeerGetter
+	for _, peer := range replicas {
+		if !skipped[peer] {
+			rest = append(rest, peer)
+		}
+	}
+	err = g.setOnPeers(rest, key, value, time.Time{})
+	g.broadcastRemove(key, append(written, rest...)...)
+	return err
+}
+
+// 只写入本地的mainCache，远程结点收到写入请求时调用这个，expire为零值表示永不过期
+func (g *Group) setLocally(key string, value []byte, expire time.Time) {
+	g.hotCache.remove(key)
+	g.removeFromDisk(key) //否则重启之后会读到旧值
+	g.populateCache(key, ByteView{b: cloneBytes(value), expire: expire}, 0)
+}
+
+// 只删除本地的缓存，远程结点收到删除请求时调用这个，否则会无限广播下去
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	if g.negCache != nil {
+		g.negCache.remove(key)
+	}
+	g.removeFromDisk(key)
+}
+
+func (g *Group) removeFromPeer(peer PeerGetter, key string) error {
+	remover, ok := peer.(PeerRemover)
+	if !ok {
+		return fmt.Errorf("peer does not support remove")
+	}
+	return remover.Remove(&pb.Request{
+		Group: g.name,
+		Key:   key,
+	})
 }
 
 func (g *Group) RegisterPeers(peers PeerPicker) {
@@ -108,23 +538,53 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 	g.peers = peers
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	//调用方已经不需要这个结果了，就没有必要再去加载
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
 	//使用Do保证并发情况下只有一个协程去网络请求，其他协程直接等待
+	leader := false //只有真正执行了下面函数的协程才是leader，其他协程都是被合并掉的
 	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
+		leader = true
+		if g.peers != nil && !isPeerRequest(ctx) {
 			//peers.PickPeer(key)这个函数我在想是不是用来判断是否是映射到本机结点?
 			//如果映射到自己结点上这个key，那么就直接调用getLocally?去对应"磁盘"中拿取数据
 
 			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
+				if value, err = g.getFromPeer(ctx, peer, key); err == nil {
+					g.stats.PeerLoads.Add(1)
 					return value, nil
 				}
+				//远程结点明确告诉我们key不存在，就没有必要再去本地查一次数据库了
+				if errors.Is(err, ErrNotFound) {
+					g.stats.PeerLoads.Add(1)
+					g.populateNegative(key)
+					return nil, err
+				}
+				g.stats.PeerErrors.Add(1)
 				log.Println("[GeeCache] Failed to get from peer", err)
+				//远程结点自己的Getter已经失败了，本地再查一次大概率也会失败，还会让数据库多承受一次请求，
+				//只有结点过载、没有这个组或者通信失败的时候才在本地加载
+				if errors.Is(err, ErrLoadFailed) {
+					return nil, err
+				}
+				//是因为自己被取消了才失败的，就不再去本地加载了
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
 			}
 		}
 		//去对应"磁盘"中拿取数据
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
+	if !leader {
+		g.stats.Dedups.Add(1)
+		//等待的是别人的请求，如果是别人的ctx被取消导致失败了，而自己的ctx还有效，那么就自己重新加载一次
+		if isContextErr(err) && ctx.Err() == nil {
+			return g.load(ctx, key)
+		}
+	}
 
 	//将对应接口进行转换并且返回回去
 	if err == nil {
@@ -134,15 +594,63 @@ func (g *Group) load(key string) (value ByteView, err error) {
 
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+type peerRequestKey struct{}
+
+// 标记这个请求是其他结点转发过来的，这样的请求只在本地加载，不会再转发给其他结点，
+// 否则各个结点看到的哈希环或者负载不一样时，请求可能会在结点之间来回转发
+func withPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerRequestKey{}, true)
+}
+
+func isPeerRequest(ctx context.Context) bool {
+	fromPeer, _ := ctx.Value(peerRequestKey{}).(bool)
+	return fromPeer
+}
+
+// 判断是否是由于ctx被取消或者超时导致的错误
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	var err error
+	if cp, ok := peer.(PeerGetterWithContext); ok {
+		err = cp.GetContext(ctx, req, res)
+	} else {
+		err = peer.Get(req, res)
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	value := ByteView{b: res.Value, expire: expireOf(res.GetExpire())}
+	//只抽样放入一部分，真正频繁访问的key大概率会被放进去，和owner中的值同时过期
+	if g.hotCache.maxBytes() > 0 && rand.Float64() < g.hotCacheSampleRate {
+		g.hotCache.addWithExpiry(key, value, value.expire)
+	}
+	return value, nil
+}
+
+// 用来区分是哪一个缓存
+type CacheType int
+
+const (
+	MainCache CacheType = iota + 1 //保存本结点负责的key
+	HotCache                       //保存其他结点负责，但是访问频繁的key
+)
+
+// 返回对应缓存的统计信息
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
 }
@@ -1,10 +1,17 @@
 package geecache
 
 import (
+	"awesomeProject2/Day7/geecache/LRU"
+	"awesomeProject2/Day7/geecache/disk"
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 var db = map[string]string{
@@ -71,3 +78,529 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("expect nil, but %s got", group.name)
 	}
 }
+
+func TestGetWithTTL(t *testing.T) {
+	loadCounts := make(map[string]int)
+	gee := NewGroup("ttl", 2<<10, GetterWithTTLFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			loadCounts[key]++
+			if key == "short" {
+				return []byte(key), 50 * time.Millisecond, nil
+			}
+			return []byte(key), 0, nil
+		}))
+	for _, k := range []string{"short", "forever"} {
+		if view, err := gee.Get(k); err != nil || view.String() != k {
+			t.Fatalf("failed to get value of %s", k)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	//过期的key需要重新加载，永不过期的key依旧命中缓存
+	for _, k := range []string{"short", "forever"} {
+		if _, err := gee.Get(k); err != nil {
+			t.Fatalf("failed to get value of %s", k)
+		}
+	}
+	if loadCounts["short"] != 2 || loadCounts["forever"] != 1 {
+		t.Fatalf("unexpected load counts %v", loadCounts)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	gee := NewGroup("sweeper", 2<<10, GetterWithTTLFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			return []byte(key), 10 * time.Millisecond, nil
+		}), WithShards(4))
+	for _, k := range []string{"Tom", "Jack", "Sam"} {
+		if _, err := gee.Get(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		gee.sweeper(5 * time.Millisecond)
+		close(stopped)
+	}()
+	//没有人访问，过期的值也会被后台协程删除
+	deadline := time.Now().Add(time.Second)
+	for gee.CacheStats(MainCache).Items != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired entries should be swept, stats %+v", gee.CacheStats(MainCache))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	gee.Close()
+	gee.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper should stop after Close")
+	}
+}
+
+// 用于测试的远程结点，记录下收到的请求
+type fakePeer struct {
+	values  map[string]string
+	missing map[string]bool //这些key会返回ErrNotFound
+	err     error           //不为nil时，不存在的key都返回这个错误
+	expire  time.Time       //返回值的过期时间
+	gets    int
+	removed []string
+}
+
+func (p *fakePeer) Get(in *pb.Request, out *pb.Response) error {
+	p.gets++
+	if v, ok := p.values[in.GetKey()]; ok {
+		out.Value = []byte(v)
+		out.Expire = unixNano(p.expire)
+		return nil
+	}
+	if p.missing[in.GetKey()] {
+		return ErrNotFound
+	}
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("fake peer does not hold %s", in.GetKey())
+}
+
+func (p *fakePeer) Set(in *pb.SetRequest) error {
+	if p.values == nil {
+		p.values = make(map[string]string)
+	}
+	p.values[in.GetKey()] = string(in.GetValue())
+	return nil
+}
+
+func (p *fakePeer) Remove(in *pb.Request) error {
+	p.removed = append(p.removed, in.GetKey())
+	return nil
+}
+
+// 用于测试的PeerPicker，所有key都映射到owner上
+type fakePicker struct {
+	owner  *fakePeer
+	others []*fakePeer
+}
+
+func (f *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if f.owner == nil {
+		return nil, false
+	}
+	return f.owner, true
+}
+
+func (f *fakePicker) AllPeers() []PeerGetter {
+	peers := []PeerGetter{f.owner}
+	for _, p := range f.others {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func TestRemove(t *testing.T) {
+	loads := 0
+	gee := NewGroup("remove", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}))
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("Tom should be removed from mainCache")
+	}
+
+	picker := &fakePicker{owner: &fakePeer{}, others: []*fakePeer{{}, {}}}
+	gee.RegisterPeers(picker)
+	if err := gee.Remove("Jack"); err != nil {
+		t.Fatal(err)
+	}
+	//owner只应该收到一次删除请求，其他结点通过广播收到
+	for _, p := range append(picker.others, picker.owner) {
+		if !reflect.DeepEqual(p.removed, []string{"Jack"}) {
+			t.Fatalf("peer should receive remove of Jack, but %v got", p.removed)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	loads := 0
+	gee := NewGroup("set", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return nil, ErrNotFound
+		}), WithNegativeCache(time.Minute))
+	if _, err := gee.Get("Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, but %v got", err)
+	}
+	value := []byte("630")
+	if err := gee.Set("Tom", value); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = '0' //Set之后修改传进去的切片不应该影响缓存
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" || loads != 1 {
+		t.Fatalf("Set value should be returned, value %q, err %v, loads %d", v.String(), err, loads)
+	}
+
+	//owner负责保存，其他结点只需要删除hotCache中的旧值
+	picker := &fakePicker{owner: &fakePeer{}, others: []*fakePeer{{}, {}}}
+	gee.RegisterPeers(picker)
+	if err := gee.Set("Tom", []byte("631")); err != nil {
+		t.Fatal(err)
+	}
+	if picker.owner.values["Tom"] != "631" || len(picker.owner.removed) != 0 {
+		t.Fatalf("owner should store Tom, values %v, removed %v", picker.owner.values, picker.owner.removed)
+	}
+	for _, p := range picker.others {
+		if !reflect.DeepEqual(p.removed, []string{"Tom"}) {
+			t.Fatalf("peer should receive remove of Tom, but %v got", p.removed)
+		}
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("stale Tom should be removed locally")
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	gee := NewGroup("hot", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be loaded from peer", key)
+		}), WithHotCacheRatio(0.5), WithHotCacheSampleRate(1))
+	owner := &fakePeer{values: db}
+	gee.RegisterPeers(&fakePicker{owner: owner})
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != db["Tom"] {
+			t.Fatalf("failed to get Tom from peer, err %v", err)
+		}
+	}
+	//第一次从远程结点拿取之后，后面都命中hotCache
+	if owner.gets != 1 {
+		t.Fatalf("expect 1 peer get, but %d got", owner.gets)
+	}
+	if s := gee.CacheStats(HotCache); s.Items != 1 || s.Hits != 2 {
+		t.Fatalf("unexpected hot cache stats %+v", s)
+	}
+	if s := gee.CacheStats(MainCache); s.Items != 0 || s.Gets != 3 {
+		t.Fatalf("unexpected main cache stats %+v", s)
+	}
+	//删除之后hotCache中也不应该存在
+	if err := gee.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.hotCache.get("Tom"); ok {
+		t.Fatal("Tom should be removed from hotCache")
+	}
+}
+
+func TestHotCacheExpire(t *testing.T) {
+	gee := NewGroup("hot-expire", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be loaded from peer", key)
+		}), WithHotCacheRatio(0.5), WithHotCacheSampleRate(1))
+	owner := &fakePeer{values: map[string]string{"Tom": "630"}, expire: time.Now().Add(50 * time.Millisecond)}
+	gee.RegisterPeers(&fakePicker{owner: owner})
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	//hotCache中的值和owner中的值同时过期
+	if view, ok := gee.hotCache.get("Tom"); !ok || !view.expire.Equal(owner.expire) {
+		t.Fatalf("hot entry should carry owner expire %v, got %v", owner.expire, view.expire)
+	}
+	time.Sleep(60 * time.Millisecond)
+	owner.values["Tom"] = "631"
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "631" {
+		t.Fatalf("expired hot entry should be reloaded from owner, value %q, err %v", view.String(), err)
+	}
+	if owner.gets != 2 {
+		t.Fatalf("expect 2 peer gets, but %d got", owner.gets)
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	gee := NewGroup("policy", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithEvictionPolicy(LRU.PolicyTinyLFU))
+	if _, ok := gee.mainCache.(*cache).lru.(*LRU.TinyLFUCache); ok {
+		t.Fatal("cache should be created lazily")
+	}
+	for i := 0; i < 2; i++ {
+		if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+			t.Fatalf("get Tom failed, value %q, err %v", v.String(), err)
+		}
+	}
+	if _, ok := gee.mainCache.(*cache).lru.(*LRU.TinyLFUCache); !ok {
+		t.Fatalf("mainCache should use tinylfu, but %T got", gee.mainCache.(*cache).lru)
+	}
+	if s := gee.CacheStats(MainCache); s.Hits != 1 || s.Items != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := make(map[string]int)
+	gee := NewGroup("negative", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads[key]++
+			if key == "broken" {
+				return nil, errors.New("db is down")
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}), WithNegativeCache(50*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but %v got", err)
+		}
+		if _, err := gee.Get("broken"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect db error, but %v got", err)
+		}
+	}
+	//只有ErrNotFound会被缓存，其他错误每次都需要重新加载
+	if s := gee.Stats(); loads["unknown"] != 1 || loads["broken"] != 3 || s.NegativeHits.Get() != 2 {
+		t.Fatalf("unexpected loads %v, stats %+v", loads, s)
+	}
+	time.Sleep(100 * time.Millisecond)
+	gee.Get("unknown")
+	if loads["unknown"] != 2 {
+		t.Fatal("tombstone should expire after ttl")
+	}
+	gee.Remove("unknown")
+	gee.Get("unknown")
+	if loads["unknown"] != 3 {
+		t.Fatal("tombstone should be removed by Remove")
+	}
+}
+
+func TestNegativeCacheFromPeer(t *testing.T) {
+	loads := 0
+	gee := NewGroup("negative-peer", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}), WithNegativeCache(time.Minute))
+	peer := &fakePeer{missing: map[string]bool{"unknown": true}}
+	gee.RegisterPeers(&fakePicker{owner: peer})
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but %v got", err)
+		}
+	}
+	//远程结点返回不存在之后，不应该再去本地加载，并且之后直接命中负缓存
+	if loads != 0 || peer.gets != 1 {
+		t.Fatalf("unexpected local loads %d, peer gets %d", loads, peer.gets)
+	}
+}
+
+func TestPeerErrorFallback(t *testing.T) {
+	loads := 0
+	gee := NewGroup("peer-errors", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}))
+	peer := &fakePeer{}
+	gee.RegisterPeers(&fakePicker{owner: peer})
+	//远程结点过载的时候，本地加载
+	peer.err = fmt.Errorf("%w: too many requests", ErrOverloaded)
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" || loads != 1 {
+		t.Fatalf("should fall back to local load, value %q, err %v", v.String(), err)
+	}
+	//远程结点的Getter失败了，本地不再加载
+	peer.err = fmt.Errorf("%w: db is down", ErrLoadFailed)
+	if _, err := gee.Get("Jack"); !errors.Is(err, ErrLoadFailed) || loads != 1 {
+		t.Fatalf("should not fall back to local load, loads %d, err %v", loads, err)
+	}
+}
+
+func TestDiskTier(t *testing.T) {
+	store, err := disk.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	loads := make(map[string]int)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads[key]++
+		return []byte(key + "-value"), nil
+	})
+	//mainCache只能放下两个key
+	gee := NewGroup("disk", 2*int64(len("key0key0-value")), getter, WithDiskTier(store))
+	for i := 0; i < 4; i++ {
+		gee.Get(fmt.Sprintf("key%d", i))
+	}
+	if store.Len() != 2 {
+		t.Fatalf("evicted keys should be spilled to disk, but %d got", store.Len())
+	}
+	if v, err := gee.Get("key0"); err != nil || v.String() != "key0-value" || loads["key0"] != 1 {
+		t.Fatalf("key0 should be loaded from disk, value %q, err %v, loads %v", v.String(), err, loads)
+	}
+	if s := gee.Stats(); s.DiskHits.Get() != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	gee.Remove("key1")
+	if _, _, err := store.Get("key1"); !errors.Is(err, disk.ErrNotFound) {
+		t.Fatal("key1 should be removed from disk")
+	}
+
+	//模拟重启，新的组使用同一个磁盘存储
+	restarted := NewGroup("disk", 2*int64(len("key0key0-value")), getter, WithDiskTier(store))
+	if v, err := restarted.Get("key0"); err != nil || v.String() != "key0-value" || loads["key0"] != 1 {
+		t.Fatalf("key0 should be loaded from disk after restart, value %q, err %v", v.String(), err)
+	}
+}
+
+func TestStatsDedups(t *testing.T) {
+	release := make(chan struct{})
+	gee := NewGroup("dedups", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release //等所有协程都进入load之后再返回
+			return []byte(key), nil
+		}))
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gee.Get("Tom")
+		}()
+	}
+	//等到所有协程都在等待同一个请求
+	for gee.stats.Gets.Get() != 5 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	s := gee.Stats()
+	if s.LocalLoads.Get() != 1 || s.Dedups.Get() != 4 || s.Items.Get() != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	type ctxKey struct{}
+	loads := 0
+	gee := NewGroup("context", 2<<10, GetterWithContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			//调用方的ctx需要原封不动地传递进来
+			return []byte(ctx.Value(ctxKey{}).(string)), nil
+		}))
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "630"))
+	if view, err := gee.GetContext(ctx, "Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("failed to get Tom, err %v", err)
+	}
+	cancel()
+	//已经缓存的key即使ctx被取消也可以拿到
+	if _, err := gee.GetContext(ctx, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	//没有缓存的key，ctx被取消之后就不应该再去加载了
+	if _, err := gee.GetContext(ctx, "Jack"); !errors.Is(err, context.Canceled) || loads != 1 {
+		t.Fatalf("expect context.Canceled without loading, but err %v, loads %d", err, loads)
+	}
+}
+
+func TestGetContextWithTTL(t *testing.T) {
+	type ctxKey struct{}
+	gee := NewGroup("context-ttl", 2<<10, GetterWithContextTTLFunc(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			return []byte(ctx.Value(ctxKey{}).(string)), time.Minute, nil
+		}))
+	ctx := context.WithValue(context.Background(), ctxKey{}, "630")
+	//context以及过期时间都需要生效
+	view, err := gee.GetContext(ctx, "Tom")
+	if err != nil || view.String() != "630" {
+		t.Fatalf("failed to get Tom, err %v", err)
+	}
+	if view.expire.IsZero() {
+		t.Fatal("Tom should have an expire time")
+	}
+}
+
+// 用于测试副本的远程结点，写入是在后台进行的，固然通过channel通知
+type replicaPeer struct {
+	fakePeer
+	sets chan *pb.SetRequest
+}
+
+func (p *replicaPeer) Set(in *pb.SetRequest) error {
+	p.sets <- in
+	return nil
+}
+
+// 自己是所有key的owner，replica是其他副本
+type replicaPicker struct {
+	replica *replicaPeer
+	other   *fakePeer
+}
+
+func (f *replicaPicker) PickPeer(key string) (PeerGetter, bool) {
+	return nil, false
+}
+
+func (f *replicaPicker) PickReplicas(key string) []PeerGetter {
+	return []PeerGetter{f.replica}
+}
+
+func (f *replicaPicker) AllPeers() []PeerGetter {
+	return []PeerGetter{f.replica, f.other}
+}
+
+func TestPopulateReplicas(t *testing.T) {
+	gee := NewGroup("replicas", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}))
+	picker := &replicaPicker{
+		replica: &replicaPeer{sets: make(chan *pb.SetRequest, 1)},
+		other:   &fakePeer{},
+	}
+	gee.RegisterPeers(picker)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get Tom, value %q, err %v", view.String(), err)
+	}
+	select {
+	case in := <-picker.replica.sets:
+		if in.GetKey() != "Tom" || string(in.GetValue()) != db["Tom"] {
+			t.Fatalf("replica should receive Tom, but %v got", in)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("replica was not populated")
+	}
+
+	//Set同时写入副本，广播删除的时候跳过副本
+	if err := gee.Set("Tom", []byte("631")); err != nil {
+		t.Fatal(err)
+	}
+	if in := <-picker.replica.sets; string(in.GetValue()) != "631" {
+		t.Fatalf("replica should receive 631, but %q got", in.GetValue())
+	}
+	if len(picker.replica.removed) != 0 || !reflect.DeepEqual(picker.other.removed, []string{"Tom"}) {
+		t.Fatalf("only other peer should receive remove, replica %v, other %v", picker.replica.removed, picker.other.removed)
+	}
+}
+
+func TestPeerRequestNotForwarded(t *testing.T) {
+	gee := NewGroup("peer-request", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	owner := &fakePeer{values: map[string]string{"Tom": "remote"}}
+	gee.RegisterPeers(&fakePicker{owner: owner})
+	//其他结点转发过来的请求只在本地加载
+	if view, err := gee.GetContext(withPeerRequest(context.Background()), "Tom"); err != nil || view.String() != "Tom" {
+		t.Fatalf("Tom should be loaded locally, value %q, err %v", view.String(), err)
+	}
+	if owner.gets != 0 {
+		t.Fatalf("peer request should not be forwarded, but owner got %d gets", owner.gets)
+	}
+}
@@ -0,0 +1,292 @@
+package geecache
+
+import (
+	"awesomeProject2/Day7/geecache/consistenthash"
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// 每一个远程结点默认建立的连接数
+const defaultGRPCConnsPerPeer = 4
+
+// 和HTTPPool类似，只不过结点之间使用gRPC通信，使用的是geecachepb.proto中的GroupCache服务
+// 结点的地址不需要带上http://，例如localhost:8001
+type GRPCPool struct {
+	pb.UnimplementedGroupCacheServer
+
+	self         string
+	connsPerPeer int               //每一个远程结点的连接数
+	dialOptions  []grpc.DialOption //建立连接时的选项，例如可以换成TLS
+	mu           sync.Mutex
+	peers        *consistenthash.Map    //和HTTPPool一样的一致性哈希
+	grpcGetters  map[string]*grpcGetter //每一个远程结点对应一个grpcGetter
+}
+
+// 创建GRPCPool时的可选配置
+type GRPCPoolOption func(p *GRPCPool)
+
+// 设置每一个远程结点建立多少个连接，请求会轮流使用这些连接
+func WithGRPCConnsPerPeer(n int) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		if n > 0 {
+			p.connsPerPeer = n
+		}
+	}
+}
+
+// 设置建立连接时的选项，默认不使用TLS
+func WithGRPCDialOptions(opts ...grpc.DialOption) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.dialOptions = opts
+	}
+}
+
+func NewGRPCPool(self string, opts ...GRPCPoolOption) *GRPCPool {
+	p := &GRPCPool{
+		self:         self,
+		connsPerPeer: defaultGRPCConnsPerPeer,
+		dialOptions:  []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s ", p.self, fmt.Sprintf(format, v...))
+}
+
+// 实现了GroupCacheServer，处理其他结点发过来的请求
+func (p *GRPCPool) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	p.Log("gRPC Get %s/%s", in.GetGroup(), in.GetKey())
+	//业务上的错误放在Response的错误码中返回，gRPC本身的错误只用来表示通信失败
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return errorResponse(fmt.Errorf("%w: %s", ErrGroupNotFound, in.GetGroup())), nil
+	}
+	view, err := group.GetContext(withPeerRequest(ctx), in.GetKey())
+	if err != nil {
+		return errorResponse(err), nil
+	}
+	return &pb.Response{Value: view.ByteSlice(), Expire: unixNano(view.expire)}, nil
+}
+
+// 批量获取，每一个key的错误单独放在对应的Response中
+func (p *GRPCPool) GetMulti(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	p.Log("gRPC GetMulti %s %d keys", in.GetGroup(), len(in.GetKeys()))
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		//每一个key都返回组不存在
+		err := fmt.Errorf("%w: %s", ErrGroupNotFound, in.GetGroup())
+		results := make([]Result, len(in.GetKeys()))
+		for i, key := range in.GetKeys() {
+			results[i] = Result{Key: key, Err: err}
+		}
+		return batchResponse(results), nil
+	}
+	return batchResponse(group.GetMultiContext(withPeerRequest(ctx), in.GetKeys())), nil
+}
+
+// 把值写入本地的缓存，不再继续转发
+func (p *GRPCPool) Put(ctx context.Context, in *pb.SetRequest) (*pb.Response, error) {
+	p.Log("gRPC Put %s/%s", in.GetGroup(), in.GetKey())
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return errorResponse(fmt.Errorf("%w: %s", ErrGroupNotFound, in.GetGroup())), nil
+	}
+	group.setLocally(in.GetKey(), in.GetValue(), expireOf(in.GetExpire()))
+	return &pb.Response{}, nil
+}
+
+// 只删除本地的缓存，不再继续广播
+func (p *GRPCPool) Remove(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	p.Log("gRPC Remove %s/%s", in.GetGroup(), in.GetKey())
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return errorResponse(fmt.Errorf("%w: %s", ErrGroupNotFound, in.GetGroup())), nil
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.Response{}, nil
+}
+
+func errorResponse(err error) *pb.Response {
+	return &pb.Response{Code: codeOf(err), Message: err.Error()}
+}
+
+// 在lis上开启gRPC服务，会一直阻塞
+// 如果已经有了自己的grpc.Server，也可以直接调用pb.RegisterGroupCacheServer(server, p)
+func (p *GRPCPool) Serve(lis net.Listener) error {
+	server := grpc.NewServer()
+	pb.RegisterGroupCacheServer(server, p)
+	return server.Serve(lis)
+}
+
+// 设置所有的结点，已经存在的结点会复用之前建立好的连接
+func (p *GRPCPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers.Add(peers...)
+	getters := make(map[string]*grpcGetter, len(peers))
+	for _, peer := range peers {
+		if peer == p.self {
+			continue
+		}
+		if g, ok := p.grpcGetters[peer]; ok {
+			getters[peer] = g
+			continue
+		}
+		g, err := newGRPCGetter(peer, p.connsPerPeer, p.dialOptions)
+		if err != nil {
+			p.Log("failed to connect to %s: %v", peer, err)
+			continue
+		}
+		getters[peer] = g
+	}
+	//不再使用的结点需要关闭对应的连接
+	for peer, g := range p.grpcGetters {
+		if _, ok := getters[peer]; !ok {
+			g.close()
+		}
+	}
+	p.grpcGetters = getters
+}
+
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		if g, ok := p.grpcGetters[peer]; ok {
+			p.Log("Pick peer %s", peer)
+			return g, true
+		}
+	}
+	return nil, false
+}
+
+// 返回除自己以外的所有远程结点，用于广播
+func (p *GRPCPool) AllPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(p.grpcGetters))
+	for _, g := range p.grpcGetters {
+		peers = append(peers, g)
+	}
+	return peers
+}
+
+// 关闭所有远程结点的连接
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.grpcGetters {
+		g.close()
+	}
+	p.grpcGetters = nil
+	return nil
+}
+
+var _ PeerPicker = (*GRPCPool)(nil)
+var _ PeerBroadcaster = (*GRPCPool)(nil)
+var _ pb.GroupCacheServer = (*GRPCPool)(nil)
+
+// 通过gRPC访问某一个远程结点，内部维护了一个连接池，请求轮流使用池子里的连接
+type grpcGetter struct {
+	addr    string
+	conns   []*grpc.ClientConn
+	clients []pb.GroupCacheClient
+	next    uint32 //下一次使用哪一个连接
+}
+
+func newGRPCGetter(addr string, n int, opts []grpc.DialOption) (*grpcGetter, error) {
+	g := &grpcGetter{addr: addr}
+	for i := 0; i < n; i++ {
+		//NewClient并不会真正建立连接，第一次请求的时候才会连接
+		conn, err := grpc.NewClient(addr, opts...)
+		if err != nil {
+			g.close()
+			return nil, err
+		}
+		g.conns = append(g.conns, conn)
+		g.clients = append(g.clients, pb.NewGroupCacheClient(conn))
+	}
+	return g, nil
+}
+
+func (g *grpcGetter) Get(in *pb.Request, out *pb.Response) error {
+	return g.GetContext(context.Background(), in, out)
+}
+
+func (g *grpcGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	idx := atomic.AddUint32(&g.next, 1) % uint32(len(g.clients))
+	res, err := g.clients[idx].Get(ctx, in)
+	if err != nil {
+		return err
+	}
+	if res.GetCode() != pb.Code_OK {
+		return errorOf(res.GetCode(), res.GetMessage())
+	}
+	proto.Reset(out)
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	idx := atomic.AddUint32(&g.next, 1) % uint32(len(g.clients))
+	res, err := g.clients[idx].GetMulti(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Reset(out)
+	proto.Merge(out, res)
+	return nil
+}
+
+// Set和Remove没有调用方的ctx，使用默认的超时时间，防止某一个结点卡住导致一直等待
+func (g *grpcGetter) Set(in *pb.SetRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+	idx := atomic.AddUint32(&g.next, 1) % uint32(len(g.clients))
+	res, err := g.clients[idx].Put(ctx, in)
+	if err != nil {
+		return err
+	}
+	return errorOf(res.GetCode(), res.GetMessage())
+}
+
+// 通知远程结点删除对应的缓存
+func (g *grpcGetter) Remove(in *pb.Request) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+	idx := atomic.AddUint32(&g.next, 1) % uint32(len(g.clients))
+	res, err := g.clients[idx].Remove(ctx, in)
+	if err != nil {
+		return err
+	}
+	return errorOf(res.GetCode(), res.GetMessage())
+}
+
+func (g *grpcGetter) close() {
+	for _, conn := range g.conns {
+		conn.Close()
+	}
+}
+
+var _ PeerGetter = (*grpcGetter)(nil)
+var _ PeerGetterWithContext = (*grpcGetter)(nil)
+var _ PeerBatchGetter = (*grpcGetter)(nil)
+var _ PeerSetter = (*grpcGetter)(nil)
+var _ PeerRemover = (*grpcGetter)(nil)
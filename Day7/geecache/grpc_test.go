@@ -0,0 +1,74 @@
+package geecache
+
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestGRPCPool(t *testing.T) {
+	gee := NewGroup("grpc", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	server := NewGRPCPool(lis.Addr().String())
+	go server.Serve(lis)
+
+	//client这个结点上所有的key都映射到server上
+	client := NewGRPCPool("client", WithGRPCConnsPerPeer(2))
+	defer client.Close()
+	client.Set(lis.Addr().String())
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatal("Tom should be picked from remote peer")
+	}
+	for i := 0; i < 3; i++ {
+		out := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: gee.name, Key: "Tom"}, out); err != nil || string(out.GetValue()) != db["Tom"] {
+			t.Fatalf("get Tom failed, value %q, err %v", out.GetValue(), err)
+		}
+	}
+	if err := peer.Get(&pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{}); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("expect ErrGroupNotFound, but %v got", err)
+	}
+	if err := peer.Get(&pb.Request{Group: gee.name, Key: "unknown"}, &pb.Response{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, but %v got", err)
+	}
+	out := &pb.BatchResponse{}
+	if err := peer.(PeerBatchGetter).GetMulti(context.Background(), &pb.BatchRequest{Group: gee.name, Keys: []string{"Jack", "unknown"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	if res := out.GetResponses(); len(res) != 2 || string(res[0].GetValue()) != db["Jack"] || res[1].GetCode() != pb.Code_NOT_FOUND {
+		t.Fatalf("unexpected responses %v", res)
+	}
+	if err := peer.(PeerSetter).Set(&pb.SetRequest{Group: gee.name, Key: "Sam", Value: []byte("999")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := gee.mainCache.get("Sam"); !ok || v.String() != "999" {
+		t.Fatalf("Sam should be stored by Put, but %q got", v.String())
+	}
+	if err := peer.(PeerRemover).Remove(&pb.Request{Group: gee.name, Key: "Sam"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Sam"); ok {
+		t.Fatal("Sam should be removed by Remove")
+	}
+	if peers := client.AllPeers(); len(peers) != 1 || peers[0] != peer {
+		t.Fatalf("client should broadcast to the server only, but %v got", peers)
+	}
+	//自己负责的key不需要请求远程结点
+	server.Set(lis.Addr().String())
+	if _, ok := server.PickPeer("Tom"); ok {
+		t.Fatal("server should not pick itself")
+	}
+}
@@ -3,36 +3,149 @@ package geecache
 import (
 	"awesomeProject2/Day7/geecache/consistenthash"
 	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	defaultBasePath = "/_geecache/"
-	defaultReplicas = 50
+	defaultBasePath            = "/_geecache/"
+	defaultReplicas            = 50
+	statsPath                  = "_stats"        //basePath下面用来查看统计信息的路径
+	batchPath                  = "_batch"        //basePath下面用来批量获取的路径，后面跟着组名
+	transferPath               = "_transfer"     //basePath下面用来迁移缓存的路径，后面跟着组名
+	defaultRequestTimeout      = 3 * time.Second //请求远程结点的默认超时时间，防止某一个结点卡住导致一直等待
+	defaultMaxIdleConnsPerPeer = 16              //每一个远程结点默认保留的空闲连接数
 )
 
+// PUT请求中值的过期时间，unix纳秒，没有这个header表示永不过期
+const expireHeader = "X-Geecache-Expire"
+
 // 这里使用http的方法，任何类型都可以实现对应go中http包的接口
 type HTTPPool struct {
 	self        string
 	basePath    string //默认这个http服务所监听的对应接口路径是basePath
 	mu          sync.Mutex
-	peers       *consistenthash.Map    //对应的一致性哈希的map，用来根据具体的key选择对应的结点
-	httpGetters map[string]*httpGetter //映射远程节点与对应的 httpGetter。每一个远程节点对应一个 httpGetter
+	peers       consistenthash.Placement //对应的一致性哈希的map，用来根据具体的key选择对应的结点
+	httpGetters map[string]*httpGetter   //映射远程节点与对应的 httpGetter。每一个远程节点对应一个 httpGetter
+	latencies   map[string]*peerLatency  //请求每一个远程结点的耗时，重新Set之后依旧保留
+	weights     map[string]int           //每一个结点的权重，迁移缓存时发送给其他结点
+	zones       map[string]string        //每一个结点所在的可用区
+	zone        string                   //自己所在的可用区
+	//下面是请求远程结点时使用的配置
+	client              *http.Client
+	transport           http.RoundTripper
+	rebalance           bool  //结点变化之后是否从其他结点拉取现在属于自己的缓存
+	transferRate        int64 //拉取时对方每秒最多发送多少字节，0表示不限制
+	rebalanceTimer      *time.Timer
+	timeout             time.Duration //每一次请求的超时时间，0表示不设置超时
+	maxIdleConnsPerPeer int
+	inflight            chan struct{} //限制同时处理的Get请求数，为nil表示不限制
+	replication         int           //每一个key保存在几个结点上，小于等于1表示不使用副本
+	loadEpsilon         float64       //大于0时开启有界负载，以正在进行的请求数作为负载
+	newPlacement        func() consistenthash.Placement
+}
+
+// 创建HTTPPool时的可选配置
+type HTTPPoolOption func(p *HTTPPool)
+
+// 使用自己的http.Client请求远程结点，例如需要使用TLS的时候
+// 设置之后WithTransport以及WithMaxIdleConnsPerPeer不再生效
+func WithHTTPClient(client *http.Client) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.client = client
+	}
+}
+
+// 使用自己的RoundTripper请求远程结点，设置之后WithMaxIdleConnsPerPeer不再生效
+func WithTransport(transport http.RoundTripper) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.transport = transport
+	}
 }
 
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
+// 设置每一次请求远程结点的超时时间，0表示不设置超时
+func WithRequestTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.timeout = timeout
+	}
+}
+
+// 设置每一个远程结点最多保留多少个空闲连接，用来复用连接
+func WithMaxIdleConnsPerPeer(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.maxIdleConnsPerPeer = n
+	}
+}
+
+// 限制同时处理的Get请求数，超过之后直接返回OVERLOADED，调用方会转而自己加载，
+// 防止某一个热点结点被请求压垮，n小于等于0表示不限制
+func WithMaxInflight(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.inflight = nil
+		if n > 0 {
+			p.inflight = make(chan struct{}, n)
+		}
+	}
+}
+
+// 开启有界负载的一致性哈希，以正在进行的请求数作为每一个结点的负载：
+// 发往远程结点的请求记在对应的结点上，自己正在处理的Get请求记在自己身上，
+// 负载超过(1+epsilon)倍平均负载的结点会被跳过，key会顺时针交给下一个结点，
+// 这样少数热点key就不会把一个结点压垮，代价是被跳过的key在其他结点上需要重新加载。
+// 开启副本时副本的选择不受影响
+func WithBoundedLoad(epsilon float64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.loadEpsilon = epsilon
+	}
+}
+
+// 使用其他的算法决定key属于哪一个结点，例如consistenthash.NewMaglev，
+// newPlacement每次都需要返回一个新的空的Placement，所有结点需要使用同样的算法。
+// 默认使用哈希环，只有哈希环支持有界负载。
+// 注意consistenthash.Jump按照名字给结点编号，而不是加入的顺序，名字排在中间的结点加入或者离开时，
+// 后面所有结点的key都会移动，只适合结点列表很少变化的集群
+func WithPlacement(newPlacement func() consistenthash.Placement) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.newPlacement = newPlacement
+	}
+}
+
+func NewHTTPPool(self string, opts ...HTTPPoolOption) *HTTPPool {
+	p := &HTTPPool{
 		//self保留自己的地址
-		self:     self,
-		basePath: defaultBasePath,
+		self:                self,
+		basePath:            defaultBasePath,
+		latencies:           make(map[string]*peerLatency),
+		timeout:             defaultRequestTimeout,
+		maxIdleConnsPerPeer: defaultMaxIdleConnsPerPeer,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	if p.client == nil {
+		transport := p.transport
+		if transport == nil {
+			//默认的http.DefaultTransport每个host只保留两个空闲连接，并发一高就会不停地新建连接
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.MaxIdleConnsPerHost = p.maxIdleConnsPerPeer
+			transport = t
+		}
+		p.client = &http.Client{Transport: transport}
+	}
+	return p
 }
 
 // 对日志的进行一个封装,参数为一个接口，表示任何值都可以传递进来
@@ -47,6 +160,21 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("HTTPPool serving unexpected path :" + r.URL.Path)
 	}
 	p.Log("%s %s ", r.Method, r.URL.Path)
+	//举例：/_geecache/_stats 返回所有组的统计信息，/_geecache/_stats/scores 只返回scores这个组的
+	if path := r.URL.Path[len(p.basePath):]; path == statsPath || strings.HasPrefix(path, statsPath+"/") {
+		p.serveStats(w, strings.TrimPrefix(strings.TrimPrefix(path, statsPath), "/"))
+		return
+	}
+	//举例：POST /_geecache/_transfer/scores，返回现在属于请求方的缓存
+	if path := r.URL.Path[len(p.basePath):]; strings.HasPrefix(path, transferPath+"/") {
+		p.serveTransfer(w, r, strings.TrimPrefix(path, transferPath+"/"))
+		return
+	}
+	//举例：POST /_geecache/_batch/scores，请求体是BatchRequest
+	if path := r.URL.Path[len(p.basePath):]; strings.HasPrefix(path, batchPath+"/") {
+		p.serveBatch(w, r, strings.TrimPrefix(path, batchPath+"/"))
+		return
+	}
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	//举例：http://localhost:9999/_geecache/scores/Tom这个url,
 	// 会变成这个/scores/Tom,然后通过分割有两个对应的字符串scores,Tom
@@ -58,18 +186,53 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := parts[1]
 	group := GetGroup(groupName)
 	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		writeError(w, fmt.Errorf("%w: %s", ErrGroupNotFound, groupName))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		//远程结点通知删除，只删除本地的，不再继续广播
+		group.removeLocally(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut:
+		//远程结点写入的值，请求体就是值本身，只写入本地的，不再继续转发
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var expire int64
+		if h := r.Header.Get(expireHeader); h != "" {
+			if expire, err = strconv.ParseInt(h, 10, 64); err != nil {
+				http.Error(w, "bad expire: "+h, http.StatusBadRequest)
+				return
+			}
+		}
+		group.setLocally(key, value, expireOf(expire))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.acquire() {
+		writeError(w, ErrOverloaded)
 		return
 	}
+	defer p.release()
+	defer p.trackLoad(p.self)()
 	//本地方法组找到对应的缓存，如果没有内部会根据回调函数返回的数据返回对应的数据，然后将其数据放入到对应的缓存结构中
-	view, err := group.Get(key)
+	//请求方断开连接之后，r.Context()会被取消，这样就不会继续加载了
+	view, err := group.GetContext(withPeerRequest(r.Context()), key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	//将值作为原始消息写入响应主体。
 
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice(), Expire: unixNano(view.expire)})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -82,29 +245,210 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// 处理批量获取的请求，每一个key的错误单独放在对应的Response中
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		writeError(w, fmt.Errorf("%w: %s", ErrGroupNotFound, groupName))
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.BatchRequest{}
+	if err := proto.Unmarshal(body, in); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	//一个批量请求只占用一个名额
+	if !p.acquire() {
+		writeError(w, ErrOverloaded)
+		return
+	}
+	defer p.release()
+	defer p.trackLoad(p.self)()
+	body, err = proto.Marshal(batchResponse(group.GetMultiContext(withPeerRequest(r.Context()), in.GetKeys())))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// 把GetMulti的结果转换成BatchResponse，HTTPPool和GRPCPool共用
+func batchResponse(results []Result) *pb.BatchResponse {
+	out := &pb.BatchResponse{Responses: make([]*pb.Response, len(results))}
+	for i, r := range results {
+		if r.Err != nil {
+			out.Responses[i] = errorResponse(r.Err)
+			continue
+		}
+		out.Responses[i] = &pb.Response{Value: r.Value.ByteSlice(), Expire: unixNano(r.Value.expire)}
+	}
+	return out
+}
+
+// 占用一个处理请求的名额，没有空闲的名额时返回false
+func (p *HTTPPool) acquire() bool {
+	if p.inflight == nil {
+		return true
+	}
+	select {
+	case p.inflight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *HTTPPool) release() {
+	if p.inflight != nil {
+		<-p.inflight
+	}
+}
+
+// 每一种错误码对应的http状态码
+var codeStatus = map[pb.Code]int{
+	pb.Code_NOT_FOUND:       http.StatusNotFound,
+	pb.Code_GROUP_NOT_FOUND: http.StatusBadRequest,
+	pb.Code_LOAD_FAILED:     http.StatusInternalServerError,
+	pb.Code_OVERLOADED:      http.StatusServiceUnavailable,
+}
+
+// 返回错误，除了http状态码之外，响应体中也会带上错误码，调用方根据错误码转换回对应的错误
+func writeError(w http.ResponseWriter, err error) {
+	code := codeOf(err)
+	body, _ := proto.Marshal(&pb.Response{Code: code, Message: err.Error()})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(codeStatus[code])
+	w.Write(body)
+}
+
+// 把出错的响应转换成错误，响应体中没有错误码时（例如中间的代理返回的），根据状态码判断
+func readError(res *http.Response) error {
+	out := &pb.Response{}
+	if res.Header.Get("Content-Type") == "application/octet-stream" {
+		if bytes, err := ioutil.ReadAll(res.Body); err == nil && proto.Unmarshal(bytes, out) == nil && out.GetCode() != pb.Code_OK {
+			return errorOf(out.GetCode(), out.GetMessage())
+		}
+	}
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusServiceUnavailable:
+		return fmt.Errorf("%w: server returned %v", ErrOverloaded, res.Status)
+	}
+	return fmt.Errorf("server returned %v", res.Status)
+}
+
+// 以json的格式返回统计信息，groupName为空表示返回所有组的
+func (p *HTTPPool) serveStats(w http.ResponseWriter, groupName string) {
+	var v interface{}
+	if groupName != "" {
+		group := GetGroup(groupName)
+		if group == nil {
+			http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+			return
+		}
+		v = group.Stats()
+	} else {
+		all := make(map[string]Stats)
+		mu.RLock()
+		for name, group := range groups {
+			all[name] = group.Stats()
+		}
+		mu.RUnlock()
+		v = all
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// 请求某一个远程结点的耗时，一次批量请求比一次Get慢得多，固然分开记录，否则会拉高Get的延迟
+type peerLatency struct {
+	get   *Histogram
+	batch *Histogram
+}
+
+func newPeerLatency() *peerLatency {
+	return &peerLatency{
+		get:   newHistogram(defaultLatencyBuckets),
+		batch: newHistogram(defaultLatencyBuckets),
+	}
+}
+
 // 表示将要访问的远程节点的地址，例如 http://example.com/_geecache/
 type httpGetter struct {
 	baseURL string
+	latency *peerLatency  //记录每次请求的耗时
+	client  *http.Client  //为nil时使用http.DefaultClient
+	timeout time.Duration //每一次请求的超时时间，0表示不设置超时
+	track   func() func() //不为nil时，每次请求开始时调用，返回的函数在请求结束之后调用
+	health  *peerHealth   //为nil时认为一直是健康的
+}
+
+// 创建一个带超时时间的请求，返回的cancel需要在读取完响应之后调用
+func (h *httpGetter) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if h.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return req, cancel, nil
+}
+
+func (h *httpGetter) httpClient() *http.Client {
+	if h.client != nil {
+		return h.client
+	}
+	return http.DefaultClient
 }
 
 // 发送方法，并接收返回值进行返回
 // baseURL 表示将要访问的远程节点的地址
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf(
-		"%v%v/%v", //这里 /不要漏掉了
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	)
+	return h.GetContext(context.Background(), in, out)
+}
+
+// 和Get一样，ctx被取消或者超时之后，请求会被中断
+func (h *httpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) (err error) {
+	defer func() { h.health.observe(ctx, err) }()
+	if h.latency != nil {
+		defer func(start time.Time) { h.latency.get.Observe(time.Since(start)) }(time.Now())
+	}
+	if h.track != nil {
+		defer h.track()()
+	}
+	req, cancel, err := h.newRequest(ctx, http.MethodGet, h.url(in), nil)
+	if err != nil {
+		return err
+	}
+	defer cancel()
 	//阻塞调用Get方法
-	res, err := http.Get(u)
+	res, err := h.httpClient().Do(req)
 	if err != nil {
 		return err
 	}
 	//关闭方法体
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned %v", res.Status)
+		return readError(res)
 	}
 	bytes, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -116,25 +460,275 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	return nil
 }
 
+// 发送POST请求，一次拿到多个key
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) (err error) {
+	defer func() { h.health.observe(ctx, err) }()
+	if h.latency != nil {
+		defer func(start time.Time) { h.latency.batch.Observe(time.Since(start)) }(time.Now())
+	}
+	if h.track != nil {
+		defer h.track()()
+	}
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	u := h.baseURL + batchPath + "/" + url.QueryEscape(in.GetGroup())
+	req, cancel, err := h.newRequest(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return readError(res)
+	}
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body %v", err)
+	}
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// 发送PUT请求，把值写入远程结点的缓存
+func (h *httpGetter) Set(in *pb.SetRequest) error {
+	u := h.url(&pb.Request{Group: in.GetGroup(), Key: in.GetKey()})
+	req, cancel, err := h.newRequest(context.Background(), http.MethodPut, u, bytes.NewReader(in.GetValue()))
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if in.GetExpire() != 0 {
+		req.Header.Set(expireHeader, strconv.FormatInt(in.GetExpire(), 10))
+	}
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return readError(res)
+	}
+	return nil
+}
+
+// 发送DELETE请求，通知远程结点删除对应的缓存
+func (h *httpGetter) Remove(in *pb.Request) error {
+	req, cancel, err := h.newRequest(context.Background(), http.MethodDelete, h.url(in), nil)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	res, err := h.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return readError(res)
+	}
+	return nil
+}
+
+// 拼接出请求对应的url
+func (h *httpGetter) url(in *pb.Request) string {
+	return fmt.Sprintf(
+		"%v%v/%v", //这里 /不要漏掉了
+		h.baseURL,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	)
+}
+
 // 定义一个没有用的对象，查看当前类型可以创建，即所有接口是否被正确实现
 // 若没实现，这里就会报错，很常见的一种设计模式
 var _ PeerGetter = (*httpGetter)(nil)
+var _ PeerGetterWithContext = (*httpGetter)(nil)
+var _ PeerRemover = (*httpGetter)(nil)
+var _ PeerBatchGetter = (*httpGetter)(nil)
+var _ PeerSetter = (*httpGetter)(nil)
+
+// 带权重的结点，Weight是虚拟结点个数相对于默认的倍数，小于等于0时当成1，
+// Zone是结点所在的可用区，用来把副本分散到不同的可用区，
+// 所有结点需要使用同样的权重以及可用区配置，否则算出的哈希环不一样
+type Peer struct {
+	Addr   string `json:"addr"`
+	Weight int    `json:"weight,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+}
+
+func (p Peer) weight() int {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
+
+// 把地址都转换成权重为1的结点
+func unweighted(addrs []string) []Peer {
+	peers := make([]Peer, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = Peer{Addr: addr, Weight: 1}
+	}
+	return peers
+}
 
 // 将一些真实结点进行设置，有种分布式存储那个项目地感觉，
 // 每个机器都有着其他结点的信息，即peer数组
 // 以及通信的通道
 func (p *HTTPPool) Set(peers ...string) {
+	p.SetPeers(unweighted(peers)...)
+}
+
+// 和Set一样，只不过每一个结点可以带上权重，例如从配置文件中读取，
+// 权重为2的结点分到的key大约是权重为1的结点的两倍
+func (p *HTTPPool) SetPeers(peers ...Peer) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
+	p.peers = p.newRing()
 	//进行初始化map
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
-	//真正开始存入其他机器的信息
+	p.weights = make(map[string]int, len(peers))
+	p.zones = make(map[string]string, len(peers))
+	p.addPeersLocked(peers)
+	p.scheduleRebalanceLocked()
+}
+
+// 增加一些结点，只会在哈希环上增加这些结点的虚拟结点，不会重建整个哈希环，
+// 固然只有一部分key会映射到新的结点上，已经存在的结点会被忽略，新的结点权重都是1
+func (p *HTTPPool) AddPeers(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		p.peers = p.newRing()
+		p.httpGetters = make(map[string]*httpGetter, len(peers))
+		p.weights = make(map[string]int, len(peers))
+		p.zones = make(map[string]string, len(peers))
+	}
+	if p.addPeersLocked(unweighted(peers)) > 0 {
+		p.scheduleRebalanceLocked()
+	}
+}
+
+// 删除一些结点，只有原本映射到这些结点上的key会重新映射
+func (p *HTTPPool) RemovePeers(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return
+	}
+	var removed []string
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		if _, ok := p.httpGetters[peer]; ok {
+			delete(p.httpGetters, peer)
+			delete(p.weights, peer)
+			delete(p.zones, peer)
+			removed = append(removed, peer)
+		}
 	}
+	p.peers.Remove(removed...)
+	//结点挂掉之后一部分key有了新的副本，需要从其他副本那里把缓存拉过来
+	if len(removed) > 0 {
+		p.scheduleRebalanceLocked()
+	}
+}
 
+// 创建一个空的哈希环，所有的哈希环都需要通过它创建，
+// 这样其他结点根据同样的结点列表就可以算出同样的哈希环
+func (p *HTTPPool) newRing() consistenthash.Placement {
+	if p.newPlacement != nil {
+		return p.newPlacement()
+	}
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.SetBoundedLoad(p.loadEpsilon)
+	return ring
+}
+
+// 开启有界负载时，在哈希环上把peer的负载加1，返回的函数用来减1，没有开启时什么都不做。
+// 减1的时候使用的是加1时的哈希环，中间重新Set过也不会算错
+func (p *HTTPPool) trackLoad(peer string) func() {
+	if p.loadEpsilon <= 0 {
+		return func() {}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ring, ok := p.peers.(consistenthash.LoadTracker)
+	if !ok {
+		return func() {}
+	}
+	ring.Inc(peer)
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		ring.Done(peer)
+	}
+}
+
+// 返回每一个结点当前的负载，没有开启有界负载时都是0
+func (p *HTTPPool) PeerLoads() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	loads := make(map[string]int64, len(p.httpGetters))
+	ring, _ := p.peers.(consistenthash.LoadTracker)
+	for peer := range p.httpGetters {
+		if ring != nil {
+			loads[peer] = ring.Load(peer)
+		}
+	}
+	return loads
+}
+
+// 调用的时候需要持有锁，返回真正增加的结点数
+func (p *HTTPPool) addPeersLocked(peers []Peer) int {
+	added := 0
+	for _, peer := range peers {
+		addr := peer.Addr
+		if _, ok := p.httpGetters[addr]; ok {
+			continue
+		}
+		if p.latencies[addr] == nil {
+			p.latencies[addr] = newPeerLatency()
+		}
+		//真正开始存入其他机器的信息
+		getter := &httpGetter{
+			baseURL: addr + p.basePath,
+			latency: p.latencies[addr],
+			client:  p.client,
+			timeout: p.timeout,
+			health:  &peerHealth{},
+		}
+		if p.loadEpsilon > 0 {
+			getter.track = func() func() { return p.trackLoad(addr) }
+		}
+		p.httpGetters[addr] = getter
+		p.weights[addr] = peer.weight()
+		p.zones[addr] = peer.Zone
+		//将对应结点放入到哈希环上
+		p.peers.AddWeighted(addr, peer.weight())
+		added++
+	}
+	return added
+}
+
+// 返回当前所有的结点，包括自己
+func (p *HTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
 }
 
 // 这个函数应该是查找对应key存放在哪一个机器上，然后通过调用远程方法去获取这个缓存
@@ -143,11 +737,77 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	defer p.mu.Unlock()
 	//并不等于自己而且不能为空，那么就表示映射成功
 	//通过哈希映射查询到对应结点应该存放到哪里
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		p.Log("Pick peer %s", peer)
-		return p.httpGetters[peer], true
+	replicas := p.replicasLocked(key)
+	if len(replicas) == 0 {
+		return nil, false
+	}
+	//自己就是owner，直接在本地加载
+	if replicas[0] == p.self {
+		return nil, false
+	}
+	//自己是其他的副本时，先请求排在自己前面的健康副本（通常就是owner），它们都失败了才在本地加载，
+	//否则每一个副本都会各自去查一次数据库。前面的副本都不健康时自己就是首选的副本，直接在本地加载
+	for i, peer := range replicas {
+		if peer != p.self {
+			continue
+		}
+		var ahead []string
+		for _, peer := range replicas[:i] {
+			if p.httpGetters[peer].health.healthy() {
+				ahead = append(ahead, peer)
+			}
+		}
+		if len(ahead) == 0 {
+			return nil, false
+		}
+		replicas = ahead
+		break
+	}
+	if len(replicas) == 1 {
+		p.Log("Pick peer %s", replicas[0])
+		return p.httpGetters[replicas[0]], true
+	}
+	//优先请求健康的、和自己在同一个可用区的副本
+	r := p.orderReplicasLocked(replicas)
+	p.Log("Pick peer %s", r.getters[0].baseURL)
+	return r, true
+}
+
+// 返回除自己以外的所有远程结点，用于广播
+func (p *HTTPPool) AllPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer == p.self {
+			continue
+		}
+		peers = append(peers, getter)
+	}
+	return peers
+}
+
+// 返回向每一个远程结点发送Get请求耗时的直方图
+func (p *HTTPPool) PeerLatencies() map[string]HistogramSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := make(map[string]HistogramSnapshot, len(p.latencies))
+	for peer, l := range p.latencies {
+		all[peer] = l.get.Snapshot()
+	}
+	return all
+}
+
+// 返回向每一个远程结点发送批量GetMulti请求耗时的直方图
+func (p *HTTPPool) PeerBatchLatencies() map[string]HistogramSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := make(map[string]HistogramSnapshot, len(p.latencies))
+	for peer, l := range p.latencies {
+		all[peer] = l.batch.Snapshot()
 	}
-	return nil, false
+	return all
 }
 
 var _ PeerPicker = (*HTTPPool)(nil)
+var _ PeerBroadcaster = (*HTTPPool)(nil)
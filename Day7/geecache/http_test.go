@@ -0,0 +1,515 @@
+package geecache
+
+import (
+	"awesomeProject2/Day7/geecache/consistenthash"
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPRemove(t *testing.T) {
+	gee := NewGroup("http-remove", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	pool := NewHTTPPool("self")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	out := &pb.Response{}
+	if err := getter.Get(&pb.Request{Group: gee.name, Key: "Tom"}, out); err != nil || string(out.GetValue()) != "Tom" {
+		t.Fatalf("get Tom failed, value %q, err %v", out.GetValue(), err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); !ok {
+		t.Fatal("Tom should be cached")
+	}
+	if err := getter.Remove(&pb.Request{Group: gee.name, Key: "Tom"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("Tom should be removed by DELETE")
+	}
+}
+
+func TestHTTPStats(t *testing.T) {
+	gee := NewGroup("http-stats", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	for i := 0; i < 3; i++ {
+		gee.Get("Tom")
+	}
+	pool := NewHTTPPool("self")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + defaultBasePath + statsPath + "/" + gee.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var stats map[string]int64
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["gets"] != 3 || stats["hits"] != 2 || stats["local_loads"] != 1 || stats["items"] != 1 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+
+	res, err = http.Get(server.URL + defaultBasePath + statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var all map[string]map[string]int64
+	if err := json.NewDecoder(res.Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all[gee.name]; !ok {
+		t.Fatalf("stats of %s should be included", gee.name)
+	}
+}
+
+func TestHTTPGetContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	NewGroup("http-context", 2<<10, GetterWithContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			select {
+			case <-release:
+			case <-ctx.Done(): //请求方取消之后，服务端的加载也会被取消
+			}
+			return nil, ctx.Err()
+		}))
+	server := httptest.NewServer(NewHTTPPool("self"))
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := getter.GetContext(ctx, &pb.Request{Group: "http-context", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, but %v got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("request should be interrupted by the deadline")
+	}
+}
+
+// 记录请求次数的RoundTripper
+type countingTransport struct {
+	n int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPPoolOptions(t *testing.T) {
+	release := make(chan struct{})
+	NewGroup("http-options", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if key == "hang" {
+				<-release //模拟一个卡住的结点
+			}
+			return []byte(key), nil
+		}))
+	server := httptest.NewServer(NewHTTPPool("self"))
+	defer server.Close()
+	defer close(release) //需要在server.Close之前让卡住的请求返回
+
+	transport := &countingTransport{}
+	pool := NewHTTPPool("self", WithTransport(transport), WithRequestTimeout(50*time.Millisecond))
+	pool.Set(server.URL)
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatal("Tom should be picked from remote peer")
+	}
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "http-options", Key: "Tom"}, out); err != nil || string(out.GetValue()) != "Tom" {
+		t.Fatalf("get Tom failed, value %q, err %v", out.GetValue(), err)
+	}
+	if transport.n != 1 {
+		t.Fatalf("custom transport should be used, but %d requests got", transport.n)
+	}
+	//卡住的结点需要在超时之后返回
+	start := time.Now()
+	if err := peer.Get(&pb.Request{Group: "http-options", Key: "hang"}, out); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, but %v got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("request should be interrupted by the timeout")
+	}
+}
+
+func TestHTTPPoolAddRemovePeers(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	pool.AddPeers("http://a", "http://b")
+	pool.AddPeers("http://b", "http://c") //b已经存在，会被忽略
+	if peers := pool.Peers(); !reflect.DeepEqual(peers, []string{"http://a", "http://b", "http://c"}) {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	owners := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = pool.peers.Get(key)
+	}
+	pool.RemovePeers("http://c")
+	if len(pool.AllPeers()) != 1 {
+		t.Fatalf("only http://b should be left as remote peer")
+	}
+	for key, owner := range owners {
+		now := pool.peers.Get(key)
+		if owner != "http://c" && now != owner {
+			t.Fatalf("%s should stay on %s, but moved to %s", key, owner, now)
+		}
+		if now == "http://c" {
+			t.Fatalf("%s should not map to removed peer", key)
+		}
+		if peer, ok := pool.PickPeer(key); ok && peer.(*httpGetter).baseURL != now+defaultBasePath {
+			t.Fatalf("%s picked wrong peer", key)
+		}
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	NewGroup("http-not-found", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+	server := httptest.NewServer(NewHTTPPool("self"))
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	err := getter.Get(&pb.Request{Group: "http-not-found", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, but %v got", err)
+	}
+	//组不存在不能被当成key不存在
+	err = getter.Get(&pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrGroupNotFound) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrGroupNotFound, but %v got", err)
+	}
+}
+
+func TestHTTPErrorCodes(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	NewGroup("http-errors", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if key == "hang" {
+				close(started)
+				<-release
+				return []byte(key), nil
+			}
+			return nil, errors.New("db is down")
+		}))
+	server := httptest.NewServer(NewHTTPPool("self", WithMaxInflight(1)))
+	defer server.Close()
+	defer close(release)
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	err := getter.Get(&pb.Request{Group: "http-errors", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrLoadFailed) || err.Error() != "geecache: load failed: db is down" {
+		t.Fatalf("expect ErrLoadFailed, but %v got", err)
+	}
+	//唯一的名额被卡住的请求占用之后，其他请求都会被拒绝
+	go getter.Get(&pb.Request{Group: "http-errors", Key: "hang"}, &pb.Response{})
+	<-started
+	if err := getter.Get(&pb.Request{Group: "http-errors", Key: "Tom"}, &pb.Response{}); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expect ErrOverloaded, but %v got", err)
+	}
+}
+
+func TestHTTPGetMulti(t *testing.T) {
+	NewGroup("http-multi", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}))
+	server := httptest.NewServer(NewHTTPPool("self"))
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	out := &pb.BatchResponse{}
+	if err := getter.GetMulti(context.Background(), &pb.BatchRequest{Group: "http-multi", Keys: []string{"Tom", "unknown", "Sam"}}, out); err != nil {
+		t.Fatal(err)
+	}
+	res := out.GetResponses()
+	if len(res) != 3 || string(res[0].GetValue()) != "630" || res[1].GetCode() != pb.Code_NOT_FOUND || string(res[2].GetValue()) != "567" {
+		t.Fatalf("unexpected responses %v", res)
+	}
+	err := getter.GetMulti(context.Background(), &pb.BatchRequest{Group: "no-such-group", Keys: []string{"Tom"}}, &pb.BatchResponse{})
+	if !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("expect ErrGroupNotFound, but %v got", err)
+	}
+}
+
+func TestHTTPSet(t *testing.T) {
+	gee := NewGroup("http-set", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := httptest.NewServer(NewHTTPPool("self"))
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	if err := getter.Set(&pb.SetRequest{Group: gee.name, Key: "Tom", Value: []byte("630")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := gee.mainCache.get("Tom"); !ok || v.String() != "630" {
+		t.Fatalf("Tom should be stored by PUT, but %q got", v.String())
+	}
+	//副本带着过期时间写入
+	expire := time.Now().Add(time.Minute)
+	if err := getter.Set(&pb.SetRequest{Group: gee.name, Key: "Jack", Value: []byte("589"), Expire: expire.UnixNano()}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := gee.mainCache.get("Jack"); !ok || !v.expire.Equal(expireOf(expire.UnixNano())) {
+		t.Fatalf("Jack should expire at %v, but %v got", expire, v.expire)
+	}
+	err := getter.Set(&pb.SetRequest{Group: "no-such-group", Key: "Tom", Value: []byte("630")})
+	if !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("expect ErrGroupNotFound, but %v got", err)
+	}
+}
+
+func TestHTTPReplication(t *testing.T) {
+	NewGroup("http-replica", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	live := httptest.NewServer(NewHTTPPool("live"))
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	pool := NewHTTPPool("http://self", WithReplication(2))
+	pool.Set("http://self", dead.URL, live.URL)
+	var failover, local, secondary string
+	for i := 0; i < 1000 && (failover == "" || local == "" || secondary == ""); i++ {
+		key := fmt.Sprintf("key%d", i)
+		replicas := pool.peers.GetN(key, 2)
+		switch {
+		case reflect.DeepEqual(replicas, []string{dead.URL, live.URL}):
+			failover = key
+		case reflect.DeepEqual(replicas, []string{dead.URL, "http://self"}):
+			secondary = key
+		case replicas[0] == "http://self":
+			local = key
+		}
+	}
+	if failover == "" || local == "" || secondary == "" {
+		t.Fatal("no suitable keys found")
+	}
+
+	//第一个副本挂掉之后，请求第二个副本
+	peer, ok := pool.PickPeer(failover)
+	if !ok {
+		t.Fatalf("%s should be picked from peers", failover)
+	}
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "http-replica", Key: failover}, out); err != nil || string(out.GetValue()) != failover {
+		t.Fatalf("failed to get %s from replica, value %q, err %v", failover, out.GetValue(), err)
+	}
+
+	//自己是owner，在本地加载，其他副本需要被写入
+	if _, ok := pool.PickPeer(local); ok {
+		t.Fatalf("%s should be loaded locally", local)
+	}
+	if replicas := pool.PickReplicas(local); len(replicas) != 1 {
+		t.Fatalf("%s should have 1 remote replica, but %d got", local, len(replicas))
+	}
+
+	//自己是第二个副本，先请求owner
+	if peer, ok := pool.PickPeer(secondary); !ok || peer != pool.httpGetters[dead.URL] {
+		t.Fatalf("%s should be read from owner %s first", secondary, dead.URL)
+	}
+	//owner不健康之后，自己就是首选的副本，在本地加载
+	for i := 0; i < defaultMaxPeerFailures; i++ {
+		pool.httpGetters[dead.URL].health.observe(context.Background(), errors.New("connection refused"))
+	}
+	if _, ok := pool.PickPeer(secondary); ok {
+		t.Fatalf("%s should be loaded locally when owner is unhealthy", secondary)
+	}
+}
+
+// 记录收到的PUT以及DELETE请求的结点
+type recordingPeer struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (p *recordingPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.methods = append(p.methods, r.Method)
+	p.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *recordingPeer) received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.methods...)
+}
+
+// 自己是中间的副本时，Remove以及Set需要通知到前后所有的副本
+func TestHTTPMiddleReplica(t *testing.T) {
+	gee := NewGroup("http-middle-replica", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	a, b := &recordingPeer{}, &recordingPeer{}
+	sa, sb := httptest.NewServer(a), httptest.NewServer(b)
+	defer sa.Close()
+	defer sb.Close()
+	pool := NewHTTPPool("http://self", WithReplication(3))
+	pool.Set("http://self", sa.URL, sb.URL)
+	gee.RegisterPeers(pool)
+	var key string
+	for i := 0; i < 1000 && key == ""; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if reflect.DeepEqual(pool.replicasLocked(k), []string{sa.URL, "http://self", sb.URL}) {
+			key = k
+		}
+	}
+	if key == "" {
+		t.Fatal("no suitable key found")
+	}
+
+	if err := gee.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.received(); !reflect.DeepEqual(got, []string{http.MethodDelete}) {
+		t.Fatalf("owner should receive one DELETE, but %v got", got)
+	}
+	if got := b.received(); !reflect.DeepEqual(got, []string{http.MethodDelete}) {
+		t.Fatalf("replica after self should receive one DELETE, but %v got", got)
+	}
+
+	a.methods, b.methods = nil, nil
+	if err := gee.Set(key, []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.received(); !reflect.DeepEqual(got, []string{http.MethodPut}) {
+		t.Fatalf("owner should receive one PUT, but %v got", got)
+	}
+	if got := b.received(); !reflect.DeepEqual(got, []string{http.MethodPut}) {
+		t.Fatalf("replica after self should receive one PUT, but %v got", got)
+	}
+}
+
+func TestHTTPBoundedLoad(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/octet-stream")
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("v")})
+		w.Write(body)
+	}))
+	defer server.Close()
+	pool := NewHTTPPool("http://self", WithBoundedLoad(0.25))
+	pool.Set("http://self", server.URL, "http://other")
+	var key string
+	for i := 0; i < 1000 && key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); pool.peers.Get(k) == server.URL {
+			key = k
+		}
+	}
+	if key == "" {
+		t.Fatal("no suitable key found")
+	}
+	owner := pool.httpGetters[server.URL]
+	if peer, ok := pool.PickPeer(key); !ok || peer != owner {
+		t.Fatalf("%s should be picked from %s", key, server.URL)
+	}
+
+	//两个正在进行的请求：平均负载(2+1)/3=1，上限ceil(1.25)=2，owner已经达到上限
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- owner.Get(&pb.Request{Group: "g", Key: key}, &pb.Response{})
+		}()
+	}
+	for deadline := time.Now().Add(time.Second); pool.PeerLoads()[server.URL] != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("load of %s should be 2, but %d got", server.URL, pool.PeerLoads()[server.URL])
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if peer, ok := pool.PickPeer(key); ok && peer == owner {
+		t.Fatalf("overloaded %s should be skipped", server.URL)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if load := pool.PeerLoads()[server.URL]; load != 0 {
+		t.Fatalf("load should be 0 after requests finished, but %d got", load)
+	}
+	if peer, ok := pool.PickPeer(key); !ok || peer != owner {
+		t.Fatalf("%s should go back to %s", key, server.URL)
+	}
+}
+
+func TestHTTPPoolWeights(t *testing.T) {
+	pool := NewHTTPPool("http://self")
+	pool.SetPeers(Peer{Addr: "http://self"}, Peer{Addr: "http://a", Weight: 1}, Peer{Addr: "http://b", Weight: 2})
+	counts := make(map[string]int)
+	for i := 0; i < 30000; i++ {
+		peer, ok := pool.PickPeer(fmt.Sprintf("key%d", i))
+		if !ok {
+			counts["http://self"]++
+			continue
+		}
+		counts[strings.TrimSuffix(peer.(*httpGetter).baseURL, defaultBasePath)]++
+	}
+	//权重为2的结点分到的key大约是权重为1的两倍
+	for _, peer := range []string{"http://self", "http://a"} {
+		ratio := float64(counts["http://b"]) / float64(counts[peer])
+		if ratio < 1.6 || ratio > 2.4 {
+			t.Errorf("http://b should own about twice as many keys as %s, counts %v", peer, counts)
+		}
+	}
+}
+
+func TestHTTPPoolPlacement(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithPlacement(func() consistenthash.Placement {
+		return consistenthash.NewRendezvous()
+	}))
+	pool.Set("http://self", "http://a", "http://b")
+	want := consistenthash.NewRendezvous()
+	want.Add("http://self", "http://a", "http://b")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner := want.Get(key)
+		peer, ok := pool.PickPeer(key)
+		if owner == "http://self" {
+			if ok {
+				t.Fatalf("%s should be loaded locally", key)
+			}
+			continue
+		}
+		if !ok || peer != pool.httpGetters[owner] {
+			t.Fatalf("%s should be picked from %s", key, owner)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package metrics
+
+// 把geecache中的统计信息按照Prometheus的文本格式输出，方便Prometheus直接抓取
+// 文本格式比较简单，这里自己手动拼接，不需要引入Prometheus的客户端库
+// 格式参考：https://prometheus.io/docs/instrumenting/exposition_formats/
+
+import (
+	"awesomeProject2/Day7/geecache"
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 返回一个http.Handler，一般挂载在/metrics上，pool可以为nil，为nil时不输出远程结点的延迟
+func Handler(pool *geecache.HTTPPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Write(w, pool); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// 将所有组以及pool的统计信息写入到w中
+func Write(w io.Writer, pool *geecache.HTTPPool) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	groups := geecache.Groups()
+	stats := make([]geecache.Stats, len(groups))
+	for i, g := range groups {
+		stats[i] = g.Stats()
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(s *geecache.Stats) int64
+	}{
+		{"geecache_gets_total", "Total number of Get calls.", func(s *geecache.Stats) int64 { return s.Gets.Get() }},
+		{"geecache_hits_total", "Total number of Get calls served from mainCache or hotCache.", func(s *geecache.Stats) int64 { return s.CacheHits.Get() }},
+		{"geecache_peer_loads_total", "Total number of values loaded from remote peers.", func(s *geecache.Stats) int64 { return s.PeerLoads.Get() }},
+		{"geecache_peer_errors_total", "Total number of failed loads from remote peers.", func(s *geecache.Stats) int64 { return s.PeerErrors.Get() }},
+		{"geecache_local_loads_total", "Total number of values loaded by the Getter.", func(s *geecache.Stats) int64 { return s.LocalLoads.Get() }},
+		{"geecache_local_load_errors_total", "Total number of failed loads by the Getter.", func(s *geecache.Stats) int64 { return s.LocalLoadErrs.Get() }},
+		{"geecache_dedups_total", "Total number of loads deduplicated by singleflight.", func(s *geecache.Stats) int64 { return s.Dedups.Get() }},
+		{"geecache_negative_hits_total", "Total number of Get calls answered by the negative cache.", func(s *geecache.Stats) int64 { return s.NegativeHits.Get() }},
+		{"geecache_disk_hits_total", "Total number of values loaded from the disk tier.", func(s *geecache.Stats) int64 { return s.DiskHits.Get() }},
+	}
+	for _, c := range counters {
+		e.header(c.name, c.help, "counter")
+		for i, g := range groups {
+			e.sample(c.name, labels{"group", g.Name()}, float64(c.value(&stats[i])))
+		}
+	}
+
+	//命中率，还没有请求的时候输出0
+	e.header("geecache_hit_ratio", "Ratio of Get calls served from cache.", "gauge")
+	for i, g := range groups {
+		ratio := 0.0
+		if gets := stats[i].Gets.Get(); gets > 0 {
+			ratio = float64(stats[i].CacheHits.Get()) / float64(gets)
+		}
+		e.sample("geecache_hit_ratio", labels{"group", g.Name()}, ratio)
+	}
+
+	//每一个组的mainCache以及hotCache分别输出
+	caches := []struct {
+		name  string
+		which geecache.CacheType
+	}{{"main", geecache.MainCache}, {"hot", geecache.HotCache}}
+	perCache := []struct {
+		name, help, typ string
+		value           func(g *geecache.Group, which geecache.CacheType) int64
+	}{
+		{"geecache_cache_evictions_total", "Total number of entries evicted for lack of space.", "counter",
+			func(g *geecache.Group, which geecache.CacheType) int64 { return g.CacheStats(which).Evictions }},
+		{"geecache_cache_bytes", "Bytes currently used by the cache.", "gauge",
+			func(g *geecache.Group, which geecache.CacheType) int64 { return g.CacheStats(which).Bytes }},
+		{"geecache_cache_max_bytes", "Byte budget of the cache, 0 means unlimited.", "gauge",
+			func(g *geecache.Group, which geecache.CacheType) int64 { return g.CacheBytes(which) }},
+		{"geecache_cache_items", "Number of entries currently in the cache.", "gauge",
+			func(g *geecache.Group, which geecache.CacheType) int64 { return g.CacheStats(which).Items }},
+	}
+	for _, m := range perCache {
+		e.header(m.name, m.help, m.typ)
+		for _, g := range groups {
+			for _, c := range caches {
+				e.sample(m.name, labels{"group", g.Name(), "cache", c.name}, float64(m.value(g, c.which)))
+			}
+		}
+	}
+
+	if pool != nil {
+		e.histograms("geecache_peer_request_duration_seconds",
+			"Latency of Get requests sent to remote peers.", "peer", pool.PeerLatencies())
+		e.histograms("geecache_peer_batch_request_duration_seconds",
+			"Latency of batch GetMulti requests sent to remote peers.", "peer", pool.PeerBatchLatencies())
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// 标签，按照name,value,name,value的顺序排列
+type labels []string
+
+// 负责拼接文本，出现错误之后后面的写入都会被忽略，最后统一返回错误
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+func (e *encoder) header(name, help, typ string) {
+	e.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (e *encoder) sample(name string, l labels, value float64) {
+	e.printf("%s%s %s\n", name, l.String(), formatFloat(value))
+}
+
+// 输出一组直方图，每一个直方图通过label这个标签区分
+func (e *encoder) histograms(name, help, label string, all map[string]geecache.HistogramSnapshot) {
+	e.header(name, help, "histogram")
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) //保证每次输出的顺序一致
+	for _, k := range keys {
+		h := all[k]
+		for i, upper := range h.Buckets {
+			e.sample(name+"_bucket", labels{label, k, "le", formatFloat(upper)}, float64(h.Counts[i]))
+		}
+		e.sample(name+"_bucket", labels{label, k, "le", "+Inf"}, float64(h.Count))
+		e.sample(name+"_sum", labels{label, k}, h.Sum)
+		e.sample(name+"_count", labels{label, k}, float64(h.Count))
+	}
+}
+
+func (l labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(l); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(l[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// 标签的值中反斜杠、双引号以及换行需要转义
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"awesomeProject2/Day7/geecache"
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	gee := geecache.NewGroup("metrics", 2<<10, geecache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	for i := 0; i < 4; i++ {
+		gee.Get("Tom")
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	expects := []string{
+		"# TYPE geecache_gets_total counter\n",
+		`geecache_gets_total{group="metrics"} 4` + "\n",
+		`geecache_hit_ratio{group="metrics"} 0.75` + "\n",
+		`geecache_cache_max_bytes{group="metrics",cache="main"} 2048` + "\n",
+		`geecache_cache_items{group="metrics",cache="main"} 1` + "\n",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(out, expect) {
+			t.Fatalf("output should contain %q, but got:\n%s", expect, out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var buf bytes.Buffer
+	e := &encoder{w: bufio.NewWriter(&buf)}
+	e.histograms("latency_seconds", "help", "peer", map[string]geecache.HistogramSnapshot{
+		`http://a"b`: {Buckets: []float64{0.1, 1}, Counts: []int64{1, 3}, Count: 4, Sum: 5.5},
+	})
+	e.w.Flush()
+	expect := `# HELP latency_seconds help
+# TYPE latency_seconds histogram
+latency_seconds_bucket{peer="http://a\"b",le="0.1"} 1
+latency_seconds_bucket{peer="http://a\"b",le="1"} 3
+latency_seconds_bucket{peer="http://a\"b",le="+Inf"} 4
+latency_seconds_sum{peer="http://a\"b"} 5.5
+latency_seconds_count{peer="http://a\"b"} 4
+`
+	if buf.String() != expect {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	pool := geecache.NewHTTPPool("http://localhost:8001")
+	pool.Set("http://localhost:8001", "http://localhost:8002")
+	w := httptest.NewRecorder()
+	Handler(pool).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `geecache_peer_request_duration_seconds_count{peer="http://localhost:8002"} 0`) ||
+		!strings.Contains(w.Body.String(), `geecache_peer_batch_request_duration_seconds_count{peer="http://localhost:8002"} 0`) {
+		t.Fatalf("peer latency should be exported, but got:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,77 @@
+package geecache
+
+import (
+	"awesomeProject2/Day7/geecache/LRU"
+	"awesomeProject2/Day7/geecache/disk"
+	"time"
+)
+
+const (
+	defaultHotCacheRatio      = 0.125  //hotCache默认是mainCache的八分之一大小
+	defaultHotCacheSampleRate = 0.1    //默认十分之一的远程值会被放入hotCache
+	defaultNegativeCacheRatio = 0.0625 //负缓存默认是mainCache的十六分之一大小
+)
+
+// 创建Group时的可选配置
+type GroupOption func(g *Group)
+
+// 创建mainCache和hotCache时使用的配置，所有的GroupOption执行完之后才会根据它创建缓存，
+// 这样选项的先后顺序就不会有影响
+type cacheOptions struct {
+	cacheBytes    int64
+	hotCacheRatio float64
+	policy        LRU.PolicyType
+	shards        int
+	negativeTTL   time.Duration
+	disk          *disk.Store
+}
+
+// 设置hotCache相对于cacheBytes的比例，设置为0表示不使用hotCache
+func WithHotCacheRatio(ratio float64) GroupOption {
+	return func(g *Group) {
+		if ratio < 0 {
+			ratio = 0
+		}
+		g.opts.hotCacheRatio = ratio
+	}
+}
+
+// 设置从远程结点拿到的值放入hotCache的概率，范围是[0,1]
+func WithHotCacheSampleRate(rate float64) GroupOption {
+	return func(g *Group) {
+		g.hotCacheSampleRate = rate
+	}
+}
+
+// 设置mainCache和hotCache使用的淘汰策略，默认是LRU，
+// 有大量只访问一次的扫描请求时可以使用LRU.PolicyTinyLFU或者LRU.PolicyARC
+func WithEvictionPolicy(policy LRU.PolicyType) GroupOption {
+	return func(g *Group) {
+		g.opts.policy = policy
+	}
+}
+
+// 把缓存分成n个分片，每一个分片有自己的锁，并且平分cacheBytes，
+// 并发访问很多的时候可以减少锁的竞争，n小于等于1表示不分片
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.opts.shards = n
+	}
+}
+
+// 开启负缓存，Getter返回ErrNotFound的key会被记录ttl这么长的时间，
+// 在这段时间内再次访问会直接返回ErrNotFound，不会再去查询数据库
+func WithNegativeCache(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.opts.negativeTTL = ttl
+	}
+}
+
+// 在mainCache后面加一层磁盘存储，因为容量不足被淘汰的值会写到磁盘上，
+// Getter被调用之前会先查找磁盘，这样重启之后也不需要全部重新查询数据库。
+// store由调用方打开以及关闭，每一个组需要使用单独的目录
+func WithDiskTier(store *disk.Store) GroupOption {
+	return func(g *Group) {
+		g.opts.disk = store
+	}
+}
@@ -1,6 +1,9 @@
 package geecache
 
-import pb "awesomeProject2/Day7/geecache/geecachepb"
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+)
 
 // 方法用于根据传入的 key 选择相应节点 PeerGetter。
 type PeerPicker interface {
@@ -12,3 +15,34 @@ type PeerGetter interface {
 	//Get(group string, key string) ([]byte, error)
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// PeerGetter 可以选择实现这个接口，这样调用方的ctx就可以传递到远程请求中
+type PeerGetterWithContext interface {
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// PeerGetter 可以选择实现这个接口，用来删除远程结点上的缓存
+type PeerRemover interface {
+	Remove(in *pb.Request) error
+}
+
+// PeerPicker 可以选择实现这个接口，返回除自己以外的所有远程结点，用来广播缓存失效
+type PeerBroadcaster interface {
+	AllPeers() []PeerGetter
+}
+
+// PeerGetter 可以选择实现这个接口，一次请求拿到多个key，用于GetMulti
+type PeerBatchGetter interface {
+	GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// PeerGetter 可以选择实现这个接口，用来把值直接写入远程结点的缓存
+type PeerSetter interface {
+	Set(in *pb.SetRequest) error
+}
+
+// PeerPicker 可以选择实现这个接口，返回key所在的除自己以外的所有副本结点，
+// 自己从数据库加载之后会把值写到这些结点上，开启副本时使用
+type PeerReplicaPicker interface {
+	PickReplicas(key string) []PeerGetter
+}
@@ -0,0 +1,124 @@
+package geecache
+
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"errors"
+)
+
+// 每一个key保存在哈希环上顺时针方向的n个不同结点上，n小于等于1表示不使用副本。
+// 自己是owner时直接在本地加载，自己是其他的副本时先请求owner，owner失败了才在本地加载，
+// 否则依次请求这些副本，前面的结点失败了就请求下一个，
+// 这样某一个结点挂掉之后，它的key在其他副本上依旧有缓存，不会全部打到数据库上
+func WithReplication(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.replication = n
+	}
+}
+
+// 调用的时候需要持有锁，返回key所在的所有副本结点，第一个就是原来的owner，
+// 设置了可用区时副本会尽量分散在不同的可用区
+func (p *HTTPPool) replicasLocked(key string) []string {
+	if p.peers == nil {
+		return nil
+	}
+	return replicasOf(p.peers, p.zones, key, p.replication)
+}
+
+// 返回key所在的除自己以外的所有副本结点，没有开启副本时返回nil
+func (p *HTTPPool) PickReplicas(key string) []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.replication <= 1 {
+		return nil
+	}
+	var getters []PeerGetter
+	for _, peer := range p.replicasLocked(key) {
+		if peer != p.self {
+			getters = append(getters, p.httpGetters[peer])
+		}
+	}
+	return getters
+}
+
+// 按照顺序请求多个副本结点，前面的失败了再请求后面的
+type replicaGetter struct {
+	getters []*httpGetter
+	remote  []bool //和getters一一对应，是否在其他可用区，没有设置可用区时都是false
+}
+
+// 第i个副本失败之后是否请求下一个副本。从自己可用区的副本转到其他可用区的副本代价比较大，
+// 只有这个副本已经被认为不健康时才转过去，偶尔的一次失败（例如一次500）直接返回错误
+func (r *replicaGetter) failover(i int) bool {
+	if i+1 >= len(r.getters) {
+		return false
+	}
+	if len(r.remote) == len(r.getters) && !r.remote[i] && r.remote[i+1] {
+		return !r.getters[i].health.healthy()
+	}
+	return true
+}
+
+func (r *replicaGetter) Get(in *pb.Request, out *pb.Response) error {
+	return r.GetContext(context.Background(), in, out)
+}
+
+func (r *replicaGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	var err error
+	for i, getter := range r.getters {
+		if err = getter.GetContext(ctx, in, out); err == nil {
+			return nil
+		}
+		//key不存在或者Getter失败的时候，其他副本也是一样的结果，调用方自己被取消了也不用再试
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrLoadFailed) || ctx.Err() != nil {
+			return err
+		}
+		if !r.failover(i) {
+			return err
+		}
+	}
+	return err
+}
+
+// 按照顺序向副本批量请求，整个请求失败了再请求下一个副本，每一个key自己的错误码交给调用方处理
+func (r *replicaGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	var err error
+	for i, getter := range r.getters {
+		out.Reset()
+		if err = getter.GetMulti(ctx, in, out); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !r.failover(i) {
+			return err
+		}
+	}
+	return err
+}
+
+// 写入所有的副本，返回第一个错误
+func (r *replicaGetter) Set(in *pb.SetRequest) error {
+	var first error
+	for _, getter := range r.getters {
+		if err := getter.Set(in); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// 删除所有副本上的缓存，返回第一个错误
+func (r *replicaGetter) Remove(in *pb.Request) error {
+	var first error
+	for _, getter := range r.getters {
+		if err := getter.Remove(in); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var _ PeerGetterWithContext = (*replicaGetter)(nil)
+var _ PeerBatchGetter = (*replicaGetter)(nil)
+var _ PeerSetter = (*replicaGetter)(nil)
+var _ PeerRemover = (*replicaGetter)(nil)
+var _ PeerReplicaPicker = (*HTTPPool)(nil)
@@ -0,0 +1,98 @@
+package geecache
+
+import (
+	"awesomeProject2/Day7/geecache/LRU"
+	"time"
+)
+
+// 分片的缓存，key按照哈希值分散到多个cache上，每一个cache有自己的锁，
+// 这样访问不同分片的协程就不会互相等待。
+// 每一个分片只有cacheBytes的n分之一，固然一个值超过了分片的大小就存不进去
+type shardedCache struct {
+	shards     []*cache
+	cacheBytes int64
+}
+
+func newShardedCache(cacheBytes int64, policy LRU.PolicyType, n int, spill spillFunc) *shardedCache {
+	//每一个分片至少需要1个字节，否则0会被当成没有限制
+	if cacheBytes > 0 && int64(n) > cacheBytes {
+		n = int(cacheBytes)
+	}
+	s := &shardedCache{
+		shards:     make([]*cache, n),
+		cacheBytes: cacheBytes,
+	}
+	for i := range s.shards {
+		bytes := cacheBytes / int64(n)
+		if int64(i) < cacheBytes%int64(n) { //除不尽的部分分给前面的分片
+			bytes++
+		}
+		s.shards[i] = &cache{cacheBytes: bytes, policy: policy, spill: spill}
+	}
+	return s
+}
+
+// key所在的分片，这里直接计算FNV-1a，避免每次都分配一个hash.Hash32
+func (s *shardedCache) shard(key string) *cache {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *shardedCache) maxBytes() int64 {
+	return s.cacheBytes
+}
+
+func (s *shardedCache) add(key string, value ByteView) {
+	s.shard(key).add(key, value)
+}
+
+func (s *shardedCache) addWithExpiry(key string, value ByteView, expire time.Time) {
+	s.shard(key).addWithExpiry(key, value, expire)
+}
+
+func (s *shardedCache) addIfAbsent(key string, value ByteView, expire time.Time) bool {
+	return s.shard(key).addIfAbsent(key, value, expire)
+}
+
+func (s *shardedCache) get(key string) (value ByteView, ok bool) {
+	return s.shard(key).get(key)
+}
+
+func (s *shardedCache) remove(key string) {
+	s.shard(key).remove(key)
+}
+
+func (s *shardedCache) removeExpired() int {
+	n := 0
+	for _, c := range s.shards {
+		n += c.removeExpired()
+	}
+	return n
+}
+
+// 一个分片接着一个分片，每一个分片内部的顺序不变，恢复的时候每一个key还会回到原来的分片中
+func (s *shardedCache) entries() []cacheItem {
+	var items []cacheItem
+	for _, c := range s.shards {
+		items = append(items, c.entries()...)
+	}
+	return items
+}
+
+// 所有分片的统计信息加在一起
+func (s *shardedCache) stats() CacheStats {
+	var total CacheStats
+	for _, c := range s.shards {
+		cs := c.stats()
+		total.Bytes += cs.Bytes
+		total.Items += cs.Items
+		total.Gets += cs.Gets
+		total.Hits += cs.Hits
+		total.Evictions += cs.Evictions
+	}
+	return total
+}
@@ -0,0 +1,134 @@
+package geecache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedCache(t *testing.T) {
+	s := newShardedCache(1000, 0, 8, nil)
+	var total int64
+	for _, c := range s.shards {
+		total += c.cacheBytes
+	}
+	if len(s.shards) != 8 || total != 1000 || s.maxBytes() != 1000 {
+		t.Fatalf("cacheBytes should be split across shards, but %d in total", total)
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		s.add(key, ByteView{b: []byte(key)})
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if v, ok := s.get(key); !ok || v.String() != key {
+			t.Fatalf("cache hit %s failed", key)
+		}
+	}
+	s.remove("1")
+	if _, ok := s.get("1"); ok {
+		t.Fatal("1 should be removed")
+	}
+	if st := s.stats(); st.Items != 99 || st.Gets != 101 || st.Hits != 100 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	//分片数比字节数还多的时候，每一个分片至少需要1个字节
+	if s := newShardedCache(3, 0, 8, nil); len(s.shards) != 3 {
+		t.Fatalf("expect 3 shards, but %d got", len(s.shards))
+	}
+}
+
+func TestSpillOutsideLock(t *testing.T) {
+	var c *cache
+	var spilled []string
+	c = &cache{cacheBytes: 10, spill: func(key string, value ByteView, expire time.Time) {
+		//写磁盘的时候已经释放了锁，其他协程可以继续访问这个缓存
+		c.get(key)
+		spilled = append(spilled, key)
+	}}
+	c.add("k1", ByteView{b: []byte("1234")})
+	c.add("k2", ByteView{b: []byte("1234")})
+	if len(spilled) != 1 || spilled[0] != "k1" {
+		t.Fatalf("k1 should be spilled, but %v got", spilled)
+	}
+}
+
+func TestGroupShards(t *testing.T) {
+	gee := NewGroup("shards", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithShards(4))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := strconv.Itoa(j)
+				if v, err := gee.Get(key); err != nil || v.String() != key {
+					t.Errorf("get %s failed, value %q, err %v", key, v.String(), err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, ok := gee.mainCache.(*shardedCache); !ok {
+		t.Fatalf("mainCache should be sharded, but %T got", gee.mainCache)
+	}
+	if s := gee.CacheStats(MainCache); s.Items != 100 || s.Gets != 800 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+// 并发读同一批key，比较只有一把锁的cache和分片之后的cache
+func benchmarkParallelGet(b *testing.B, c cacheStore) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		c.add(keys[i], ByteView{b: []byte(keys[i])})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGetParallel(b *testing.B) {
+	b.Run("single", func(b *testing.B) {
+		benchmarkParallelGet(b, newCacheStore(1<<20, 0, 1, nil))
+	})
+	b.Run("sharded", func(b *testing.B) {
+		benchmarkParallelGet(b, newCacheStore(1<<20, 0, 32, nil))
+	})
+}
+
+// 读写混合，每十次操作有一次写
+func benchmarkParallelMixed(b *testing.B, c cacheStore) {
+	value := ByteView{b: []byte("value")}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := strconv.Itoa(i % 4096)
+			if i%10 == 0 {
+				c.add(key, value)
+			} else {
+				c.get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheMixedParallel(b *testing.B) {
+	b.Run("single", func(b *testing.B) {
+		benchmarkParallelMixed(b, newCacheStore(1<<16, 0, 1, nil))
+	})
+	b.Run("sharded", func(b *testing.B) {
+		benchmarkParallelMixed(b, newCacheStore(1<<16, 0, 32, nil))
+	})
+}
@@ -0,0 +1,199 @@
+package geecache
+
+// 快照的格式（数字都是小端序）：
+//
+//	magic "GEEC" | version(1)
+//	每一项：payloadLen(uvarint) | payload | crc32(payload)(4)
+//	       payload = expire(8，UnixNano，0表示永不过期) | keyLen(uvarint) | key | value
+//	结束：0(uvarint) | 项数(8)
+//
+// 每一项都有自己的校验和，最后的项数用来发现被截断的快照
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	snapshotMagic   = "GEEC"
+	snapshotVersion = 1
+)
+
+var errSnapshotCorrupted = errors.New("geecache: snapshot corrupted")
+
+// 把mainCache中所有没有过期的缓存写到w中，按照从最先被淘汰到最后被淘汰的顺序，
+// hotCache中的值属于其他结点，不会被写入
+func (g *Group) Snapshot(w io.Writer) error {
+	sw := newSnapshotWriter(w)
+	for _, item := range g.mainCache.entries() {
+		if err := sw.write(item); err != nil {
+			return err
+		}
+	}
+	return sw.close()
+}
+
+// 读取Snapshot写入的快照，放入mainCache中，已经过期的会被跳过。
+// 快照损坏时返回错误，出错之前读到的缓存依旧会被保留
+func (g *Group) Restore(r io.Reader) error {
+	sr, err := newSnapshotReader(r)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for {
+		item, err := sr.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !item.expire.IsZero() && !now.Before(item.expire) {
+			continue
+		}
+		g.mainCache.addWithExpiry(item.key, item.value, item.expire)
+	}
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	n   uint64 //已经写入的项数
+	buf []byte
+}
+
+// 文件头写到缓冲区中，bufio.Writer出错之后后面的写入都会返回这个错误，固然这里不需要检查
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	sw.w.WriteString(snapshotMagic)
+	sw.w.WriteByte(snapshotVersion)
+	return sw
+}
+
+func (sw *snapshotWriter) write(item cacheItem) error {
+	var nano int64
+	if !item.expire.IsZero() {
+		nano = item.expire.UnixNano()
+	}
+	payload := sw.buf[:0]
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(nano))
+	payload = binary.AppendUvarint(payload, uint64(len(item.key)))
+	payload = append(payload, item.key...)
+	payload = append(payload, item.value.b...)
+	sw.buf = payload
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	if _, err := sw.w.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(payload)))]); err != nil {
+		return err
+	}
+	if _, err := sw.w.Write(payload); err != nil {
+		return err
+	}
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(payload))
+	if _, err := sw.w.Write(crc[:]); err != nil {
+		return err
+	}
+	sw.n++
+	return nil
+}
+
+// 写入结束标记以及项数，并且把缓冲区中的数据写出去
+func (sw *snapshotWriter) close() error {
+	trailer := binary.AppendUvarint(nil, 0)
+	trailer = binary.LittleEndian.AppendUint64(trailer, sw.n)
+	if _, err := sw.w.Write(trailer); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+type snapshotReader struct {
+	r *bufio.Reader
+	n uint64 //已经读到的项数
+}
+
+// 检查文件头
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(sr.r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", errSnapshotCorrupted, err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic", errSnapshotCorrupted)
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return nil, fmt.Errorf("geecache: unsupported snapshot version %d", v)
+	}
+	return sr, nil
+}
+
+// 读取下一项，读到结束标记并且项数正确时返回io.EOF
+func (sr *snapshotReader) read() (cacheItem, error) {
+	size, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return cacheItem{}, fmt.Errorf("%w: %v", errSnapshotCorrupted, err)
+	}
+	if size == 0 {
+		var count [8]byte
+		if _, err := io.ReadFull(sr.r, count[:]); err != nil {
+			return cacheItem{}, fmt.Errorf("%w: %v", errSnapshotCorrupted, err)
+		}
+		if n := binary.LittleEndian.Uint64(count[:]); n != sr.n {
+			return cacheItem{}, fmt.Errorf("%w: expect %d entries, but %d got", errSnapshotCorrupted, n, sr.n)
+		}
+		return cacheItem{}, io.EOF
+	}
+	//长度被写坏的时候不能直接按照它分配内存
+	payload, err := readN(sr.r, size)
+	if err != nil {
+		return cacheItem{}, fmt.Errorf("%w: %v", errSnapshotCorrupted, err)
+	}
+	var crc [4]byte
+	if _, err := io.ReadFull(sr.r, crc[:]); err != nil {
+		return cacheItem{}, fmt.Errorf("%w: %v", errSnapshotCorrupted, err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(crc[:]) {
+		return cacheItem{}, fmt.Errorf("%w: checksum mismatch", errSnapshotCorrupted)
+	}
+	if len(payload) < 8 {
+		return cacheItem{}, fmt.Errorf("%w: short entry", errSnapshotCorrupted)
+	}
+	var item cacheItem
+	if nano := int64(binary.LittleEndian.Uint64(payload)); nano != 0 {
+		item.expire = time.Unix(0, nano)
+	}
+	keyLen, n := binary.Uvarint(payload[8:])
+	if n <= 0 || keyLen > uint64(len(payload)-8-n) {
+		return cacheItem{}, fmt.Errorf("%w: bad key length", errSnapshotCorrupted)
+	}
+	rest := payload[8+n:]
+	item.key = string(rest[:keyLen])
+	item.value = ByteView{b: rest[keyLen:]}
+	sr.n++
+	return item, nil
+}
+
+// 读取n个字节，每次最多分配一小块，数据不够的时候不会分配一大块内存
+func readN(r io.Reader, n uint64) ([]byte, error) {
+	const chunk = 1 << 20
+	var buf []byte
+	for uint64(len(buf)) < n {
+		size := n - uint64(len(buf))
+		if size > chunk {
+			size = chunk
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, size)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,71 @@
+package geecache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newSnapshotGroup(name string, cacheBytes int64) *Group {
+	return NewGroup(name, cacheBytes, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}))
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	src := newSnapshotGroup("snapshot-src", 2<<10)
+	src.Set("Tom", []byte("630"))
+	src.Set("Jack", []byte("589"))
+	src.Set("Sam", []byte("567"))
+	src.mainCache.addWithExpiry("short", ByteView{b: []byte("v")}, time.Now().Add(time.Hour))
+	src.mainCache.addWithExpiry("expired", ByteView{b: []byte("v")}, time.Now().Add(-time.Second))
+	src.Get("Tom") //Tom变成最近访问的
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	//只能放下三个key，最先被淘汰的Jack不应该出现
+	dst := newSnapshotGroup("snapshot-dst", int64(len("Tom630short1Sam567")))
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"Tom": "630", "Sam": "567", "short": "v"} {
+		if v, ok := dst.mainCache.get(key); !ok || v.String() != want {
+			t.Fatalf("%s should be restored, but %q got", key, v.String())
+		}
+	}
+	for _, key := range []string{"Jack", "expired"} {
+		if _, ok := dst.mainCache.get(key); ok {
+			t.Fatalf("%s should not be restored", key)
+		}
+	}
+}
+
+func TestRestoreCorrupted(t *testing.T) {
+	src := newSnapshotGroup("snapshot-corrupted", 2<<10)
+	src.Set("Tom", []byte("630"))
+	src.Set("Jack", []byte("589"))
+	var buf bytes.Buffer
+	src.Snapshot(&buf)
+	data := buf.Bytes()
+
+	cases := map[string][]byte{
+		"truncated": data[:len(data)-3],
+		"flipped":   append(append([]byte(nil), data[:10]...), append([]byte{data[10] ^ 0xff}, data[11:]...)...),
+		"magic":     append([]byte("XXXX"), data[4:]...),
+	}
+	for name, b := range cases {
+		dst := newSnapshotGroup("snapshot-corrupted-"+name, 2<<10)
+		if err := dst.Restore(bytes.NewReader(b)); !errors.Is(err, errSnapshotCorrupted) {
+			t.Fatalf("%s: expect corrupted error, but %v got", name, err)
+		}
+	}
+	version := append([]byte(nil), data...)
+	version[4] = snapshotVersion + 1
+	if err := newSnapshotGroup("snapshot-version", 2<<10).Restore(bytes.NewReader(version)); err == nil {
+		t.Fatal("unknown version should be rejected")
+	}
+}
@@ -0,0 +1,109 @@
+package geecache
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 一个可以被原子操作的int64，用来做统计计数，多个协程同时累加也不需要加锁
+type AtomicInt int64
+
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Group的统计信息
+type Stats struct {
+	Gets          AtomicInt `json:"gets"`            //Get被调用的次数
+	CacheHits     AtomicInt `json:"hits"`            //mainCache或者hotCache命中的次数
+	PeerLoads     AtomicInt `json:"peer_loads"`      //从远程结点成功拿到的次数
+	PeerErrors    AtomicInt `json:"peer_errors"`     //从远程结点拿取失败的次数
+	LocalLoads    AtomicInt `json:"local_loads"`     //调用回调函数成功的次数
+	LocalLoadErrs AtomicInt `json:"local_load_errs"` //调用回调函数失败的次数
+	Dedups        AtomicInt `json:"dedups"`          //被singleflight合并掉的请求次数
+	NegativeHits  AtomicInt `json:"negative_hits"`   //命中负缓存，直接返回ErrNotFound的次数
+	DiskHits      AtomicInt `json:"disk_hits"`       //在磁盘上找到，不需要调用Getter的次数
+	//下面这些是从mainCache和hotCache中汇总出来的
+	Evictions AtomicInt `json:"evictions"`
+	Bytes     AtomicInt `json:"bytes"`
+	Items     AtomicInt `json:"items"`
+}
+
+// 返回当前统计信息的一份拷贝
+func (g *Group) Stats() Stats {
+	var s Stats
+	s.Gets.Add(g.stats.Gets.Get())
+	s.CacheHits.Add(g.stats.CacheHits.Get())
+	s.PeerLoads.Add(g.stats.PeerLoads.Get())
+	s.PeerErrors.Add(g.stats.PeerErrors.Get())
+	s.LocalLoads.Add(g.stats.LocalLoads.Get())
+	s.LocalLoadErrs.Add(g.stats.LocalLoadErrs.Get())
+	s.Dedups.Add(g.stats.Dedups.Get())
+	s.NegativeHits.Add(g.stats.NegativeHits.Get())
+	s.DiskHits.Add(g.stats.DiskHits.Get())
+	for _, cs := range []CacheStats{g.mainCache.stats(), g.hotCache.stats()} {
+		s.Evictions.Add(cs.Evictions)
+		s.Bytes.Add(cs.Bytes)
+		s.Items.Add(cs.Items)
+	}
+	return s
+}
+
+// 默认的延迟分桶，单位是秒
+var defaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// 一个简单的延迟直方图，每个桶都是原子计数，可以被多个协程同时记录
+type Histogram struct {
+	buckets []float64   //每个桶的上界，单位是秒，从小到大排序
+	counts  []AtomicInt //落在每一个桶里面的次数，最后一个是超过所有上界的
+	count   AtomicInt
+	sum     AtomicInt //所有耗时的总和，单位是纳秒
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]AtomicInt, len(buckets)+1),
+	}
+}
+
+// 记录一次耗时
+func (h *Histogram) Observe(d time.Duration) {
+	idx := sort.SearchFloat64s(h.buckets, d.Seconds())
+	h.counts[idx].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+// 直方图在某一个时刻的拷贝
+type HistogramSnapshot struct {
+	Buckets []float64 //每个桶的上界，单位是秒
+	Counts  []int64   //小于等于对应上界的累计次数，和Buckets一一对应
+	Count   int64     //总次数
+	Sum     float64   //总耗时，单位是秒
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  make([]int64, len(h.buckets)),
+	}
+	var cumulative int64
+	for i := range h.buckets {
+		cumulative += h.counts[i].Get()
+		s.Counts[i] = cumulative
+	}
+	s.Count = cumulative + h.counts[len(h.buckets)].Get()
+	s.Sum = time.Duration(h.sum.Get()).Seconds()
+	return s
+}
@@ -0,0 +1,272 @@
+package geecache
+
+// 结点变化之后的缓存迁移：
+// 新结点加入之后，哈希环上有一部分key从其他结点变成了属于它，但是它的缓存是空的，
+// 这些key的请求会全部打到数据库上。开启迁移之后，结点在哈希环变化之后会向其他所有结点
+// 发送自己看到的结点列表，对方用同样的结点列表算出哈希环，把现在属于请求方的缓存发送过来。
+// 开启副本之后，请求方是key的任意一个副本都会发送过去。
+// 发送的格式和快照一样，并且按照transferRate限速，避免影响对方正常处理请求。
+// 对方那里的旧值不会被删除，不再有请求之后会被慢慢淘汰掉。
+// 请求方已经有的key不会被覆盖，迁移过来的值可能比它自己刚加载或者写入的旧
+
+import (
+	"awesomeProject2/Day7/geecache/consistenthash"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 哈希环变化之后等待多久再开始迁移，gossip发现结点的时候会连续调用好几次AddPeers，
+// 等一会儿可以把它们合并成一次
+const defaultRebalanceDelay = time.Second
+
+// 开启结点变化之后的缓存迁移，rate是对方每秒最多发送多少字节，0表示不限制
+func WithRebalance(rate int64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.rebalance = true
+		p.transferRate = rate
+	}
+}
+
+// 迁移请求的请求体
+type transferRequest struct {
+	Owner string `json:"owner"` //请求方自己的地址
+	Peers []Peer `json:"peers"` //请求方看到的所有结点以及权重，包括自己
+	//请求方哈希环的版本以及摘要，对方算出的哈希环不一样时拒绝发送，否则会发送错误的key
+	Version int    `json:"version"`
+	Digest  uint64 `json:"digest,omitempty"`
+}
+
+// 哈希环的摘要，不支持摘要的Placement返回0，这时只检查版本
+func ringDigest(ring consistenthash.Placement) uint64 {
+	if d, ok := ring.(interface{ Digest() uint64 }); ok {
+		return d.Digest()
+	}
+	return 0
+}
+
+// 调用的时候需要持有锁
+func (p *HTTPPool) scheduleRebalanceLocked() {
+	if !p.rebalance {
+		return
+	}
+	if p.rebalanceTimer != nil {
+		p.rebalanceTimer.Stop()
+	}
+	p.rebalanceTimer = time.AfterFunc(defaultRebalanceDelay, func() {
+		n, err := p.Rebalance(context.Background())
+		if err != nil {
+			p.Log("rebalance failed after %d entries: %v", n, err)
+			return
+		}
+		p.Log("rebalance received %d entries", n)
+	})
+}
+
+// 从其他所有结点拉取现在属于自己的缓存，放入对应组的mainCache中，返回收到的缓存个数。
+// 开启WithRebalance之后，结点变化时会自动调用
+func (p *HTTPPool) Rebalance(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	req := transferRequest{Owner: p.self, Version: consistenthash.Version, Digest: ringDigest(p.peers)}
+	var getters []*httpGetter
+	for peer, getter := range p.httpGetters {
+		req.Peers = append(req.Peers, Peer{Addr: peer, Weight: p.weights[peer], Zone: p.zones[peer]})
+		if peer != p.self {
+			getters = append(getters, getter)
+		}
+	}
+	p.mu.Unlock()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		total    int
+		firstErr error
+	)
+	for _, g := range Groups() {
+		for _, getter := range getters {
+			wg.Add(1)
+			go func(g *Group, getter *httpGetter) {
+				defer wg.Done()
+				n, err := getter.transfer(ctx, g.name, req, func(item cacheItem) {
+					g.mainCache.addIfAbsent(item.key, item.value, item.expire)
+				})
+				mu.Lock()
+				defer mu.Unlock()
+				total += n
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("transfer %s from %s: %v", g.name, getter.baseURL, err)
+				}
+			}(g, getter)
+		}
+	}
+	wg.Wait()
+	return total, firstErr
+}
+
+// 把这个结点中现在属于请求方的缓存发送过去
+func (p *HTTPPool) serveTransfer(w http.ResponseWriter, r *http.Request, groupName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		writeError(w, fmt.Errorf("%w: %s", ErrGroupNotFound, groupName))
+		return
+	}
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Version != consistenthash.Version {
+		http.Error(w, fmt.Sprintf("ring version %d, want %d", req.Version, consistenthash.Version), http.StatusBadRequest)
+		return
+	}
+	ring := p.newRing()
+	zones := make(map[string]string, len(req.Peers))
+	for _, peer := range req.Peers {
+		ring.AddWeighted(peer.Addr, peer.weight())
+		zones[peer.Addr] = peer.Zone
+	}
+	if digest := ringDigest(ring); req.Digest != 0 && digest != req.Digest {
+		http.Error(w, fmt.Sprintf("ring digest %x, want %x", digest, req.Digest), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	var out io.Writer = w
+	if p.transferRate > 0 {
+		out = newRateLimitedWriter(r.Context(), w, p.transferRate)
+	}
+	sw := newSnapshotWriter(out)
+	n := 0
+	for _, item := range group.mainCache.entries() {
+		if !contains(replicasOf(ring, zones, item.key, p.replication), req.Owner) {
+			continue
+		}
+		//请求方断开之后就不再继续发送
+		if r.Context().Err() != nil {
+			return
+		}
+		if err := sw.write(item); err != nil {
+			return
+		}
+		n++
+	}
+	if err := sw.close(); err != nil {
+		return
+	}
+	p.Log("transferred %d entries of %s to %s", n, groupName, req.Owner)
+}
+
+// 请求远程结点发送现在属于req.Owner的缓存，每收到一个就调用一次fn，返回收到的个数
+func (h *httpGetter) transfer(ctx context.Context, group string, req transferRequest, fn func(cacheItem)) (int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	//限速之后可能需要很久，这里不使用每次请求的超时时间，只受ctx控制
+	u := h.baseURL + transferPath + "/" + url.QueryEscape(group)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	res, err := h.httpClient().Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, readError(res)
+	}
+	sr, err := newSnapshotReader(res.Body)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	n := 0
+	for {
+		item, err := sr.read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if !item.expire.IsZero() && !now.Before(item.expire) {
+			continue
+		}
+		fn(item)
+		n++
+	}
+}
+
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// 令牌桶限速的Writer，每秒最多写入rate个字节，最多可以累积一秒的令牌，
+// ctx被取消之后不再等待令牌，直接返回错误
+type rateLimitedWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimitedWriter(ctx context.Context, w io.Writer, rate int64) *rateLimitedWriter {
+	return &rateLimitedWriter{ctx: ctx, w: w, rate: float64(rate), last: time.Now()}
+}
+
+func (l *rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		//每次最多写入一秒的量，否则令牌永远不够
+		n := len(p)
+		if float64(n) > l.rate {
+			n = int(l.rate)
+			if n < 1 {
+				n = 1
+			}
+		}
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+		l.last = now
+		if need := float64(n) - l.tokens; need > 0 {
+			timer := time.NewTimer(time.Duration(need / l.rate * float64(time.Second)))
+			select {
+			case <-timer.C:
+			case <-l.ctx.Done():
+				timer.Stop()
+				return written, l.ctx.Err()
+			}
+			l.tokens += need
+			l.last = time.Now()
+		}
+		l.tokens -= float64(n)
+		m, err := l.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
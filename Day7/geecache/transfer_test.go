@@ -0,0 +1,155 @@
+package geecache
+
+import (
+	"awesomeProject2/Day7/geecache/consistenthash"
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransfer(t *testing.T) {
+	gee := NewGroup("transfer", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	keys := []string{"Tom", "Jack", "Sam", "Alice", "Bob", "Carol", "Dave", "Eve"}
+	for _, key := range keys {
+		gee.populateCache(key, ByteView{b: []byte(key)}, 0)
+	}
+	pool := NewHTTPPool("http://a")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	ring := pool.newRing()
+	ring.Add("http://a")
+	ring.AddWeighted("http://b", 2)
+	req := transferRequest{
+		Owner:   "http://b",
+		Peers:   []Peer{{Addr: "http://a"}, {Addr: "http://b", Weight: 2}},
+		Version: consistenthash.Version,
+		Digest:  ringDigest(ring),
+	}
+	want := map[string]bool{}
+	for _, key := range keys {
+		if ring.Get(key) == req.Owner {
+			want[key] = true
+		}
+	}
+	if len(want) == 0 || len(want) == len(keys) {
+		t.Fatalf("expected keys on both peers, got %d of %d on %s", len(want), len(keys), req.Owner)
+	}
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	got := map[string]bool{}
+	n, err := getter.transfer(context.Background(), gee.name, req, func(item cacheItem) {
+		if item.value.String() != item.key {
+			t.Errorf("value of %s is %q", item.key, item.value.String())
+		}
+		got[item.key] = true
+	})
+	if err != nil || n != len(want) {
+		t.Fatalf("transfer returned %d, %v, want %d entries", n, err, len(want))
+	}
+	for key := range want {
+		if !got[key] {
+			t.Errorf("%s was not transferred", key)
+		}
+	}
+
+	if _, err := getter.transfer(context.Background(), "unknown", req, func(cacheItem) {}); err == nil {
+		t.Fatal("expected error for unknown group")
+	}
+	//对方算出的哈希环不一样时拒绝发送
+	stale := req
+	stale.Peers = []Peer{{Addr: "http://a"}, {Addr: "http://b"}}
+	if _, err := getter.transfer(context.Background(), gee.name, stale, func(cacheItem) {}); err == nil {
+		t.Fatal("expected error for mismatched ring digest")
+	}
+	stale = req
+	stale.Version = consistenthash.Version - 1
+	if _, err := getter.transfer(context.Background(), gee.name, stale, func(cacheItem) {}); err == nil {
+		t.Fatal("expected error for mismatched ring version")
+	}
+}
+
+func TestRateLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newRateLimitedWriter(context.Background(), &buf, 20000)
+	data := make([]byte, 2000)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//20000个字节，每秒20000个，一开始没有令牌，至少需要1秒左右
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Fatalf("wrote %d bytes in %v, rate limit not applied", buf.Len(), d)
+	}
+	if buf.Len() != 20000 {
+		t.Fatalf("wrote %d bytes, want 20000", buf.Len())
+	}
+}
+
+func TestRateLimitedWriterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newRateLimitedWriter(ctx, &bytes.Buffer{}, 10)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	//100个字节需要等10秒，取消之后需要马上返回
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 100)); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, but %v got", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("write should stop after cancel, but took %v", d)
+	}
+}
+
+func TestTransferKeepsExisting(t *testing.T) {
+	c := newCacheStore(2<<10, 0, 4, nil)
+	c.add("Tom", ByteView{b: []byte("new")})
+	//迁移过来的值不能覆盖本地已经有的值
+	if c.addIfAbsent("Tom", ByteView{b: []byte("old")}, time.Time{}) {
+		t.Fatal("Tom should not be overwritten")
+	}
+	if !c.addIfAbsent("Jack", ByteView{b: []byte("589")}, time.Time{}) {
+		t.Fatal("Jack should be added")
+	}
+	if v, _ := c.get("Tom"); v.String() != "new" {
+		t.Fatalf("Tom should keep new value, but %q got", v.String())
+	}
+	if v, _ := c.get("Jack"); v.String() != "589" {
+		t.Fatalf("Jack should be 589, but %q got", v.String())
+	}
+}
+
+func TestRemovePeersSchedulesRebalance(t *testing.T) {
+	pool := NewHTTPPool("http://a", WithRebalance(0), WithReplication(2))
+	pool.Set("http://a", "http://b", "http://c")
+	stop := func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		scheduled := pool.rebalanceTimer != nil
+		if scheduled {
+			pool.rebalanceTimer.Stop()
+			pool.rebalanceTimer = nil
+		}
+		return scheduled
+	}
+	stop()
+	//不存在的结点不会改变哈希环
+	pool.RemovePeers("http://unknown")
+	if stop() {
+		t.Fatal("removing unknown peer should not schedule a rebalance")
+	}
+	//例如gossip发现结点挂掉了
+	pool.RemovePeers("http://c")
+	if !stop() {
+		t.Fatal("removing a peer should schedule a rebalance")
+	}
+}
@@ -0,0 +1,130 @@
+package geecache
+
+import (
+	"awesomeProject2/Day7/geecache/consistenthash"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxPeerFailures = 3                //连续失败多少次之后认为结点不健康
+	defaultUnhealthyPeriod = 10 * time.Second //结点被认为不健康之后，多久之后再优先请求它
+)
+
+// 设置自己所在的可用区，例如机房或者机架。
+// 开启副本之后，每一个key的副本会尽量分散在不同的可用区，
+// 读取时优先请求和自己在同一个可用区的副本，只有这个副本不健康或者请求失败时才请求其他可用区的副本
+func WithZone(zone string) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.zone = zone
+	}
+}
+
+// key所在的n个副本结点：沿着ring.GetN的顺序，先从每一个还没有副本的可用区中选一个，
+// 所有可用区都有副本之后再按照顺序补齐，第一个依旧是原来的owner。
+// 没有设置可用区的结点都当成同一个可用区，固然所有结点都没有设置时和ring.GetN一样
+func replicasOf(ring consistenthash.Placement, zones map[string]string, key string, n int) []string {
+	if n <= 1 {
+		if peer := ring.Get(key); peer != "" {
+			return []string{peer}
+		}
+		return nil
+	}
+	distinct := make(map[string]bool)
+	for _, zone := range zones {
+		distinct[zone] = true
+	}
+	if len(distinct) <= 1 {
+		return ring.GetN(key, n)
+	}
+	candidates := ring.GetN(key, len(zones))
+	replicas := make([]string, 0, n)
+	picked := make(map[string]bool, n)
+	covered := make(map[string]bool, len(distinct))
+	for _, peer := range candidates {
+		if len(replicas) == n {
+			break
+		}
+		if !covered[zones[peer]] {
+			covered[zones[peer]] = true
+			picked[peer] = true
+			replicas = append(replicas, peer)
+		}
+	}
+	for _, peer := range candidates {
+		if len(replicas) == n {
+			break
+		}
+		if !picked[peer] {
+			replicas = append(replicas, peer)
+		}
+	}
+	return replicas
+}
+
+// 调用的时候需要持有锁，决定请求副本的顺序：健康的结点在前面，
+// 健康的结点中和自己在同一个可用区的在前面，其余的保持原来的顺序
+func (p *HTTPPool) orderReplicasLocked(replicas []string) *replicaGetter {
+	ordered := append([]string(nil), replicas...)
+	rank := make(map[string]int, len(replicas))
+	for _, peer := range replicas {
+		if !p.httpGetters[peer].health.healthy() {
+			rank[peer] = 2
+		} else if p.zone == "" || p.zones[peer] != p.zone {
+			rank[peer] = 1
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rank[ordered[i]] < rank[ordered[j]]
+	})
+	r := &replicaGetter{
+		getters: make([]*httpGetter, len(ordered)),
+		remote:  make([]bool, len(ordered)),
+	}
+	for i, peer := range ordered {
+		r.getters[i] = p.httpGetters[peer]
+		r.remote[i] = p.zone != "" && p.zones[peer] != p.zone
+	}
+	return r
+}
+
+// 记录一个远程结点最近的请求结果，连续失败多次之后的一段时间内认为它不健康
+type peerHealth struct {
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// 根据一次请求的结果更新状态，结点正常返回了错误（例如key不存在）也算成功
+func (h *peerHealth) observe(ctx context.Context, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrLoadFailed), errors.Is(err, ErrGroupNotFound):
+		h.failures = 0
+		h.downUntil = time.Time{}
+	case ctx.Err() != nil:
+		//调用方自己取消了，不是结点的问题
+	default:
+		h.failures++
+		if h.failures >= defaultMaxPeerFailures {
+			h.failures = 0
+			h.downUntil = time.Now().Add(defaultUnhealthyPeriod)
+		}
+	}
+}
+
+func (h *peerHealth) healthy() bool {
+	if h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !time.Now().Before(h.downUntil)
+}
@@ -0,0 +1,130 @@
+package geecache
+
+import (
+	pb "awesomeProject2/Day7/geecache/geecachepb"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func zonePeers() []Peer {
+	var peers []Peer
+	for _, zone := range []string{"a", "b", "c"} {
+		for i := 0; i < 2; i++ {
+			peers = append(peers, Peer{Addr: fmt.Sprintf("http://%s%d", zone, i), Zone: zone})
+		}
+	}
+	return peers
+}
+
+func TestReplicasAcrossZones(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithReplication(3))
+	pool.SetPeers(zonePeers()...)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		replicas := pool.replicasLocked(key)
+		if len(replicas) != 3 || replicas[0] != pool.peers.Get(key) {
+			t.Fatalf("replicas of %s should start with the owner %s, but %v got", key, pool.peers.Get(key), replicas)
+		}
+		zones := map[string]bool{}
+		for _, peer := range replicas {
+			zones[pool.zones[peer]] = true
+		}
+		if len(zones) != 3 {
+			t.Fatalf("replicas of %s should be in 3 zones, but %v got", key, replicas)
+		}
+	}
+}
+
+func TestPickPeerPrefersZone(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithReplication(2), WithZone("a"))
+	pool.SetPeers(append(zonePeers(), Peer{Addr: "http://self", Zone: "a"})...)
+	//找一个owner在其他可用区，另一个副本在自己可用区的key
+	var key, local string
+	for i := 0; i < 1000 && key == ""; i++ {
+		k := fmt.Sprintf("key%d", i)
+		replicas := pool.replicasLocked(k)
+		if pool.zones[replicas[0]] != "a" && replicas[1] != "http://self" && pool.zones[replicas[1]] == "a" {
+			key, local = k, replicas[1]
+		}
+	}
+	if key == "" {
+		t.Fatal("no suitable key found")
+	}
+	peer, ok := pool.PickPeer(key)
+	if !ok || peer.(*replicaGetter).getters[0] != pool.httpGetters[local] {
+		t.Fatalf("%s should be read from %s in the same zone first", key, local)
+	}
+
+	//自己可用区的副本连续失败之后，优先请求其他可用区的副本
+	health := pool.httpGetters[local].health
+	for i := 0; i < defaultMaxPeerFailures; i++ {
+		health.observe(context.Background(), errors.New("connection refused"))
+	}
+	peer, _ = pool.PickPeer(key)
+	if getters := peer.(*replicaGetter).getters; getters[0] == pool.httpGetters[local] || getters[1] != pool.httpGetters[local] {
+		t.Fatalf("unhealthy %s should be tried last", local)
+	}
+	health.observe(context.Background(), nil)
+	if peer, _ = pool.PickPeer(key); peer.(*replicaGetter).getters[0] != pool.httpGetters[local] {
+		t.Fatalf("%s should be preferred again after it recovered", local)
+	}
+}
+
+func TestReplicaCrossZoneFailover(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer local.Close()
+	var remoteGets atomic.Int64
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteGets.Add(1)
+		http.Error(w, "should not be asked", http.StatusInternalServerError)
+	}))
+	defer remote.Close()
+	r := &replicaGetter{
+		getters: []*httpGetter{
+			{baseURL: local.URL + defaultBasePath, health: &peerHealth{}},
+			{baseURL: remote.URL + defaultBasePath, health: &peerHealth{}},
+		},
+		remote: []bool{false, true},
+	}
+	//自己可用区的副本偶尔失败时直接返回错误，不去请求其他可用区
+	for i := 0; i < defaultMaxPeerFailures-1; i++ {
+		if err := r.Get(&pb.Request{Group: "g", Key: "Tom"}, &pb.Response{}); err == nil {
+			t.Fatal("expect error from local replica")
+		}
+	}
+	if n := remoteGets.Load(); n != 0 {
+		t.Fatalf("remote zone should not be asked, but got %d requests", n)
+	}
+	//连续失败之后自己可用区的副本不健康了，这时才去请求其他可用区
+	r.Get(&pb.Request{Group: "g", Key: "Tom"}, &pb.Response{})
+	if n := remoteGets.Load(); n != 1 {
+		t.Fatalf("remote zone should be asked once local replica is unhealthy, but got %d requests", n)
+	}
+}
+
+func TestPeerHealth(t *testing.T) {
+	h := &peerHealth{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	//调用方取消以及结点正常返回的错误都不算失败
+	for i := 0; i < defaultMaxPeerFailures; i++ {
+		h.observe(ctx, context.Canceled)
+		h.observe(context.Background(), ErrNotFound)
+	}
+	if !h.healthy() {
+		t.Fatal("peer should still be healthy")
+	}
+	for i := 0; i < defaultMaxPeerFailures; i++ {
+		h.observe(context.Background(), ErrOverloaded)
+	}
+	if h.healthy() {
+		t.Fatal("peer should be unhealthy after consecutive failures")
+	}
+}
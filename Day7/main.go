@@ -2,10 +2,22 @@ package main
 
 import (
 	"awesomeProject2/Day7/geecache"
+	"awesomeProject2/Day7/geecache/consistenthash"
+	"awesomeProject2/Day7/geecache/discovery"
+	"awesomeProject2/Day7/geecache/disk"
+	"awesomeProject2/Day7/geecache/metrics"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var db = map[string]string{
@@ -14,28 +26,47 @@ var db = map[string]string{
 	"Sam":  "567",
 }
 
-func createGroup() *geecache.Group {
+func createGroup(opts ...geecache.GroupOption) *geecache.Group {
+	opts = append([]geecache.GroupOption{geecache.WithNegativeCache(10 * time.Second)}, opts...)
 	return geecache.NewGroup("scores", 2<<10, geecache.GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist ", key)
-		}))
+			//包装成ErrNotFound，不存在的key就会被负缓存记录下来，一段时间内不会再查询数据库
+			return nil, fmt.Errorf("%s not exist: %w", key, geecache.ErrNotFound)
+		}), opts...)
 }
 
 // 用来启动缓存服务器：创建 HTTPPool，添加节点信息
 // 注册到 gee 中，启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知
-func startCacheServer(addr string, addrs []string, gee *geecache.Group) {
-	peers := geecache.NewHTTPPool(addr)
-	//将对应结点放入到哈希环上
-	peers.Set(addrs...)
+// gossipAddr不为空时，通过gossip协议自动发现其他结点，不再使用写死的addrs
+func startCacheServer(addr string, addrs []geecache.Peer, gee *geecache.Group, gossipAddr string, seeds []string, opts ...geecache.HTTPPoolOption) {
+	peers := geecache.NewHTTPPool(addr, opts...)
+	if gossipAddr != "" {
+		_, err := discovery.Start(discovery.Config{
+			BindAddr:   gossipAddr,
+			Meta:       addr,
+			Seeds:      seeds,
+			Membership: peers, //有结点加入或者挂掉的时候，自动更新哈希环
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		//将对应结点放入到哈希环上
+		peers.SetPeers(addrs...)
+	}
 	//实现了一个多态，因为HTTPPool实现了PeerPicker的方法
 	gee.RegisterPeers(peers)
+	//除了结点之间通信的接口之外，再暴露一个/metrics给Prometheus抓取
+	mux := http.NewServeMux()
+	mux.Handle("/_geecache/", peers)
+	mux.Handle("/metrics", metrics.Handler(peers))
 	log.Println("geecache is running at", addr)
 	//进行监听，对应端口,开启服务
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
 }
 
 // APIServer用于与用户真正进行交互
@@ -46,6 +77,10 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 			key := r.URL.Query().Get("key") //获取对应的key参数，即获取url上面的key参数
 			//获取对应的缓存
 			view, err := gee.Get(key)
+			if errors.Is(err, geecache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -59,9 +94,86 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
 }
 
+// 从快照文件中恢复缓存，文件不存在的时候什么都不做
+func restoreSnapshot(gee *geecache.Group, path string) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Println("failed to open snapshot", err)
+		return
+	}
+	defer f.Close()
+	if err := gee.Restore(f); err != nil {
+		log.Println("failed to restore snapshot", err)
+		return
+	}
+	log.Println("restored snapshot from", path)
+}
+
+// 先写到临时文件再重命名，写到一半被杀掉也不会破坏上一次的快照
+func saveSnapshot(gee *geecache.Group, path string) {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		log.Println("failed to create snapshot", err)
+		return
+	}
+	err = gee.Snapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Println("failed to save snapshot", err)
+		return
+	}
+	log.Println("saved snapshot to", path)
+}
+
+// 解析8001=2,8002=1这样按照端口配置的值
+func parsePortValues(s string) map[int]string {
+	values := make(map[int]string)
+	if s == "" {
+		return values
+	}
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			log.Fatalf("invalid item %q", item)
+		}
+		port, err := strconv.Atoi(kv[0])
+		if err != nil {
+			log.Fatalf("invalid item %q: %v", item, err)
+		}
+		values[port] = kv[1]
+	}
+	return values
+}
+
+// 解析8001=2,8002=1这样的权重配置，没有配置的结点权重为1
+func parseWeights(s string) map[int]int {
+	weights := make(map[int]int)
+	for port, v := range parsePortValues(s) {
+		weight, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid weight %q: %v", v, err)
+		}
+		weights[port] = weight
+	}
+	return weights
+}
+
 func main() {
 	var port int
 	var api bool
+	var gossip, seeds, diskDir, snapshot string
+	var transferRate int64
+	var replicas int
+	var loadEpsilon float64
+	var weights, placement, zones string
 	//定义一个整型的命令行标志。
 	//&port: 指向一个整型变量的指针，用于存储解析后的值。
 	//"port": 命令行中使用的标志名称。
@@ -69,6 +181,16 @@ func main() {
 	//"Geecache server port": 该标志的描述信息，通常用于帮助信息
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&gossip, "gossip", "", "Gossip UDP address, e.g. localhost:7001, empty to use the fixed peers")
+	flag.StringVar(&seeds, "seeds", "", "Comma separated gossip addresses of seed nodes")
+	flag.StringVar(&diskDir, "disk", "", "Directory of the on-disk tier behind the memory cache, empty to disable")
+	flag.StringVar(&snapshot, "snapshot", "", "Directory of snapshot files restored on boot and written on SIGTERM, one file per port, empty to disable")
+	flag.Int64Var(&transferRate, "transfer-rate", -1, "Bytes per second when handing off cache entries after the ring changes, 0 for unlimited, negative to disable")
+	flag.IntVar(&replicas, "replicas", 1, "Number of peers each key is cached on")
+	flag.Float64Var(&loadEpsilon, "bounded-load", 0, "Skip peers whose in-flight requests exceed (1+epsilon) times the average, 0 to disable")
+	flag.StringVar(&weights, "weights", "", "Comma separated port=weight of the fixed peers, e.g. 8001=2, default weight is 1")
+	flag.StringVar(&placement, "placement", "ring", "Key placement algorithm: ring, rendezvous, jump or maglev (jump remaps most keys when a node other than the last by name joins or leaves)")
+	flag.StringVar(&zones, "zones", "", "Comma separated port=zone of the fixed peers, e.g. 8001=a, replicas are spread across zones")
 	//解析命令行参数。调用这个函数后，port 和 api 变量将被设置为用户在命令行中提供的值（如果有的话）。
 	flag.Parse()
 	apiAddr := "http://localhost:9999"
@@ -77,13 +199,74 @@ func main() {
 		8002: "http://localhost:8002",
 		8003: "http://localhost:8003",
 	}
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	weightMap := parseWeights(weights)
+	zoneMap := parsePortValues(zones)
+	var addrs []geecache.Peer
+	for p, v := range addrMap {
+		addrs = append(addrs, geecache.Peer{Addr: v, Weight: weightMap[p], Zone: zoneMap[p]})
+	}
+	var opts []geecache.GroupOption
+	var store *disk.Store
+	if diskDir != "" {
+		//每一个结点使用自己的子目录，方便在同一台机器上启动多个结点
+		var err error
+		store, err = disk.Open(filepath.Join(diskDir, strconv.Itoa(port)), 64<<20)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, geecache.WithDiskTier(store))
+	}
+	gee := createGroup(opts...)
+	if snapshot != "" {
+		//和-disk一样每一个结点使用自己的文件，否则同一台机器上的多个结点会互相覆盖快照
+		if err := os.MkdirAll(snapshot, 0755); err != nil {
+			log.Fatal(err)
+		}
+		snapshot = filepath.Join(snapshot, strconv.Itoa(port)+".snapshot")
+		restoreSnapshot(gee, snapshot)
+	}
+	if snapshot != "" || store != nil {
+		//收到SIGTERM之后先保存快照再退出，滚动发布的时候新的进程可以直接从快照中恢复，不会一下子全部打到数据库上
+		go func() {
+			ch := make(chan os.Signal, 1)
+			signal.Notify(ch, syscall.SIGTERM, os.Interrupt)
+			<-ch
+			if snapshot != "" {
+				saveSnapshot(gee, snapshot)
+			}
+			gee.Close()
+			if store != nil {
+				store.Close()
+			}
+			os.Exit(0)
+		}()
 	}
-	gee := createGroup()
 	if api {
 		go startAPIServer(apiAddr, gee)
 	}
-	startCacheServer(addrMap[port], addrs, gee)
+	var seedList []string
+	if seeds != "" {
+		seedList = strings.Split(seeds, ",")
+	}
+	poolOpts := []geecache.HTTPPoolOption{
+		geecache.WithReplication(replicas),
+		geecache.WithBoundedLoad(loadEpsilon),
+		geecache.WithZone(zoneMap[port]), //读取时优先请求同一个可用区的副本
+	}
+	if transferRate >= 0 {
+		//结点变化之后从原来的结点拉取现在属于自己的缓存
+		poolOpts = append(poolOpts, geecache.WithRebalance(transferRate))
+	}
+	switch placement {
+	case "ring":
+	case "rendezvous":
+		poolOpts = append(poolOpts, geecache.WithPlacement(func() consistenthash.Placement { return consistenthash.NewRendezvous() }))
+	case "jump":
+		poolOpts = append(poolOpts, geecache.WithPlacement(func() consistenthash.Placement { return consistenthash.NewJump() }))
+	case "maglev":
+		poolOpts = append(poolOpts, geecache.WithPlacement(func() consistenthash.Placement { return consistenthash.NewMaglev(0) }))
+	default:
+		log.Fatalf("unknown placement %q", placement)
+	}
+	startCacheServer(addrMap[port], addrs, gee, gossip, seedList, poolOpts...)
 }